@@ -6,10 +6,7 @@
 package main
 
 import (
-	"bufio"
-	"io"
-	"log"
-	"os/exec"
+	"fmt"
 	"path"
 	"sort"
 	"strings"
@@ -17,98 +14,149 @@ import (
 )
 
 type Cache struct {
-	packages Packages
+	packages      Packages
+	repos         []Repository
+	installed     map[string][]NEVRA
+	installedPkgs Packages
+	info          map[string]*mediaInfo // by media name
+	warnings      []error
+	names         *lazyNameIndex
 }
 
-func NewCache() *Cache {
-	c := &Cache{}
+// CacheOptions selects the metadata layers NewCache loads. The synthesis
+// and the rpm database are always loaded.
+type CacheOptions struct {
+	// Load info.xml.lzma (description, URL, license and source package) of
+	// all media. Otherwise it's loaded on demand by WithInfo.
+	Info bool
 
-	info := map[string]InfoRecord{}
-	installed := map[string]string{}
+	// Skip the media which can't be loaded instead of failing, the errors
+	// are returned by Warnings.
+	SkipBrokenMedia bool
+}
+
+// MediaErrors are the errors of all media NewCache failed to load.
+type MediaErrors []error
+
+func (e MediaErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	res := fmt.Sprintf("%d media can't be loaded:", len(e))
+	for _, err := range e {
+		res += "\n    " + err.Error()
+	}
+	return res
+}
+
+// mediaInfo is the info.xml.lzma of a media, loaded once when it is
+// needed.
+type mediaInfo struct {
+	repo    Repository
+	once    sync.Once
+	records map[string]InfoRecord
+	err     error
+}
+
+func (m *mediaInfo) load() (map[string]InfoRecord, error) {
+	m.once.Do(func() {
+		m.records, m.err = LoadMediaInfo(m.repo)
+	})
+	return m.records, m.err
+}
+
+// NewCache loads the packages of the enabled media and the rpm database.
+// The errors of all broken media are returned together as MediaErrors.
+func NewCache(opts CacheOptions) (*Cache, error) {
+	c := &Cache{info: map[string]*mediaInfo{}, names: &lazyNameIndex{}}
 
 	var wg sync.WaitGroup
 
 	// Get info about repositories
 	repos, err := GetRepositories()
 	if err != nil {
-		log.Fatal("Can't read urpi.cfg file: ", err)
+		return nil, fmt.Errorf("Can't read urpmi.cfg file: %v", err)
 	}
+	c.repos = repos
 
 	wg.Add(1)
 
+	var dbErr error
 	go func() {
 		defer wg.Done()
-		installed = fillInstalledInfo()
+		c.installedPkgs, dbErr = fillInstalledInfo()
 	}()
 
-	syntesisChan := make(chan Package, 9999)
-	var synthWg sync.WaitGroup
-
-	infoChan := make(chan InfoRecord, 9999)
-	var infoWg sync.WaitGroup
-
-	for _, repo := range repos {
-
+	// **************************************
+	// Load packages from the media index or parse the media files
+	media := make([]Packages, len(repos))
+	mediaErrs := make([]error, len(repos))
+	for i, repo := range repos {
 		if repo.Ignore {
 			continue
 		}
 
-		// **************************************
-		// Parse synthesis.hdlist.cz file
-		synthWg.Add(1)
-		go func(r Repository) {
-			defer synthWg.Done()
-			err = ReadSynthesisFile(r, r.Dir+"/synthesis.hdlist.cz", syntesisChan)
+		info := &mediaInfo{repo: repo}
+		c.info[repo.Name] = info
+
+		wg.Add(1)
+		go func(i int, r Repository) {
+			defer wg.Done()
+			pkgs, err := LoadMedia(r)
 			if err != nil {
-				log.Fatal("Can't read synthesis file ", r.Dir+"/synthesis.hdlist.cz", ": ", err)
+				mediaErrs[i] = err
+				return
 			}
-		}(repo)
-
-		// **************************************
-		// Parse info.xml.lzma file
-		infoWg.Add(1)
-		go func(r Repository) {
-			defer infoWg.Done()
-			err = ReadInfoFile(r.Dir+"/info.xml.lzma", infoChan)
-			if err != nil {
-				log.Fatal("Can't read info file ", r.Dir+"/info.xml.lzma", ": ", err)
+			media[i] = pkgs
+
+			if opts.Info {
+				records, err := info.load()
+				if err != nil {
+					mediaErrs[i] = err
+					return
+				}
+
+				for j := range pkgs {
+					if inf, ok := records[pkgs[j].FileName]; ok {
+						pkgs[j].setInfo(inf)
+					}
+				}
 			}
-		}(repo)
+		}(i, repo)
 	}
 
-	go func() {
-		synthWg.Wait()
-		close(syntesisChan)
-	}()
-
-	go func() {
-		infoWg.Wait()
-		close(infoChan)
-	}()
-
-	// **************************************
-	// Save data from synthesis.hdlist.cz file
+	wg.Wait()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for pkg := range syntesisChan {
-			c.packages = append(c.packages, pkg)
+	if dbErr != nil {
+		return nil, fmt.Errorf("Can't read rpm database: %v", dbErr)
+	}
 
+	errs := MediaErrors{}
+	for i, err := range mediaErrs {
+		if err != nil {
+			errs = append(errs, err)
+			continue
 		}
-	}()
+		c.packages = append(c.packages, media[i]...)
+	}
 
-	// **************************************
-	// Save data from info.xml.lzma file
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for i := range infoChan {
-			info[i.Filename] = i
+	if len(errs) > 0 {
+		if !opts.SkipBrokenMedia {
+			return nil, errs
 		}
-	}()
+		c.warnings = errs
+	}
 
-	wg.Wait()
+	installed := map[string][]NEVRA{}
+	for _, pkg := range c.installedPkgs {
+		installed[pkg.Name] = append(installed[pkg.Name], NEVRA{
+			Name:    pkg.Name,
+			Epoch:   pkg.Epoch,
+			Version: pkg.Version,
+			Arch:    pkg.Arch,
+		})
+	}
 
 	sort.Sort(c.packages)
 	n := 0
@@ -116,56 +164,133 @@ func NewCache() *Cache {
 		if n < len(pkg.Name) {
 			n = len(pkg.Name)
 		}
-		inf, ok := info[pkg.FileName]
-		if ok {
-			pkg.Sourcerpm = inf.Sourcerpm
-			pkg.URL = inf.URL
-			pkg.License = inf.License
-			pkg.Description = inf.Description
-
-			c.packages[i] = pkg
-		}
 
-		ver, ok := installed[pkg.Name]
+		inst, ok := installed[pkg.Name]
 		if ok {
-			pkg.InstalledVer = ver
+			pkg.Installed = inst
 			c.packages[i] = pkg
 		}
 	}
+	c.installed = installed
 
-	return c
+	return c, nil
 }
 
-func fillInstalledInfo() map[string]string {
-	res := map[string]string{}
-	cmd := exec.Command("rpm", "-q", "-a", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\n")
-	stdout, err := cmd.StdoutPipe()
+// Warnings returns the errors of the media skipped by the SkipBrokenMedia
+// option.
+func (c Cache) Warnings() []error {
+	return c.warnings
+}
+
+// fillInstalledInfo reads the installed packages from the rpm database.
+func fillInstalledInfo() (Packages, error) {
+	headers, err := ReadRpmDb(RpmDbDir)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		log.Fatal(err)
-	}
+	res := make(Packages, 0, len(headers))
+	for _, h := range headers {
+		pkg := h.Package()
 
-	r := bufio.NewReader(stdout)
-	for err == nil {
-		line, err := r.ReadString('\n')
-		if err == io.EOF {
-			break
+		// Like rpm, the files of the installed packages satisfy the file
+		// requires, e.g. /bin/sh
+		provided := map[string]bool{}
+		for _, p := range pkg.Provides {
+			provided[p.Name] = true
 		}
 
-		if err != nil {
-			log.Fatal("can't get installed versions:", err)
+		for _, f := range h.Files() {
+			if !provided[f] {
+				provided[f] = true
+				pkg.Provides = append(pkg.Provides, Dependency{Name: f})
+			}
 		}
 
-		line = strings.Trim(line, "\n\r")
-		items := strings.Split(line, "\t")
-		res[items[0]] = items[1]
+		res = append(res, pkg)
 	}
+
+	sort.Sort(res)
+	return res, nil
+}
+
+// Installed returns the installed packages with their dependencies from
+// the rpm database.
+func (c Cache) Installed() Packages {
+	media := map[string]Package{}
+	for _, pkg := range c.packages {
+		if pkg.IsInstalled() {
+			media[pkg.Name+"-"+pkg.FullVersion()+"."+pkg.Arch] = pkg
+		}
+	}
+
+	res := make(Packages, 0, len(c.installedPkgs))
+	for _, pkg := range c.installedPkgs {
+		pkg.Installed = c.installed[pkg.Name]
+		if m, ok := media[pkg.Name+"-"+pkg.FullVersion()+"."+pkg.Arch]; ok {
+			pkg.Repository = m.Repository
+			pkg.FileName = m.FileName
+			pkg.RPMSize = m.RPMSize
+		}
+		res = append(res, pkg)
+	}
+
 	return res
 }
 
+// WithInfo returns pkg with the description, URL, license and source
+// package from the info.xml.lzma of its media. The file is read on the
+// first call for the media.
+func (c Cache) WithInfo(pkg Package) (Package, error) {
+	info, ok := c.info[pkg.Repository]
+	if !ok {
+		return pkg, nil
+	}
+
+	records, err := info.load()
+	if err != nil {
+		return pkg, err
+	}
+
+	if inf, ok := records[pkg.FileName]; ok {
+		pkg.setInfo(inf)
+	}
+	return pkg, nil
+}
+
+// setInfo copies the data from the info record to the package.
+func (p *Package) setInfo(inf InfoRecord) {
+	p.Sourcerpm = inf.Sourcerpm
+	p.URL = inf.URL
+	p.License = inf.License
+	p.Description = inf.Description
+}
+
+// Repositories returns the media the cache was loaded from.
+func (c Cache) Repositories() []Repository {
+	return c.repos
+}
+
+// FindSource returns the source package of pkg from the source media.
+// The SOURCERPM tag looks like "name-version-release.src.rpm", so the
+// cache must be loaded with the Info option.
+func (c Cache) FindSource(pkg Package) (Package, bool) {
+	items := strings.Split(strings.TrimSuffix(pkg.Sourcerpm, ".src.rpm"), "-")
+	if len(items) < 3 {
+		return Package{}, false
+	}
+
+	name := strings.Join(items[:len(items)-2], "-")
+	version := items[len(items)-2] + "-" + items[len(items)-1]
+
+	for _, p := range c.packages {
+		if p.Arch == "src" && p.Name == name && p.Version == version {
+			return p, true
+		}
+	}
+	return Package{}, false
+}
+
 type packagesVerSorted []Package
 
 func (p packagesVerSorted) Len() int {
@@ -177,7 +302,7 @@ func (p packagesVerSorted) Swap(i, j int) {
 }
 
 func (p packagesVerSorted) Less(i, j int) bool {
-	return CompareVer(p[i].Version, p[j].Version) > 0
+	return p[i].CompareVersion(p[j]) > 0
 }
 
 func compareName(names []string, pkg Package) bool {
@@ -210,6 +335,184 @@ func compareArch(arch []string, pkg Package) bool {
 }
 
 func (c Cache) SearchByName(names []string, arch []string, onlyLast bool) <-chan Package {
+	return c.search(names, arch, onlyLast, func(pkg Package) bool {
+		return compareName(names, pkg)
+	})
+}
+
+// SearchExact returns packages whose names are exactly equal to one of names.
+func (c Cache) SearchExact(names []string, arch []string, onlyLast bool) <-chan Package {
+	return c.search(names, arch, onlyLast, func(pkg Package) bool {
+		for _, n := range names {
+			if strings.EqualFold(n, pkg.Name) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RequiresIndex keeps the data WhatRequires needs besides the media
+// packages. It's built once per command, because whatrequires --recursive
+// calls WhatRequires for every node of the tree.
+type RequiresIndex struct {
+	Installed Packages
+
+	cache     Cache
+	required  map[string]bool // the paths of the file requires
+	filesOnce sync.Once
+	files     map[string][]string // required paths by package file name
+}
+
+// NewRequiresIndex returns the index for the cache. The files.xml.lzma of
+// the media are read on the first use.
+func (c Cache) NewRequiresIndex() *RequiresIndex {
+	idx := &RequiresIndex{Installed: c.Installed(), cache: c, required: map[string]bool{}}
+
+	for _, pkgs := range []Packages{c.packages, idx.Installed} {
+		for _, pkg := range pkgs {
+			for _, req := range pkg.Requires {
+				if strings.HasPrefix(req.Name, "/") && req.Flags == "" {
+					idx.required[req.Name] = true
+				}
+			}
+		}
+	}
+	return idx
+}
+
+// packageFiles returns the files of the package which are required by some
+// package. The files of the installed packages are known from the rpm
+// database, for the others files.xml.lzma of the media is used.
+func (idx *RequiresIndex) packageFiles(pkg Package) map[string]bool {
+	res := map[string]bool{}
+
+	for _, inst := range idx.Installed {
+		if inst.Name == pkg.Name && inst.Arch == pkg.Arch && inst.FullVersion() == pkg.FullVersion() {
+			for _, p := range inst.Provides {
+				if idx.required[p.Name] {
+					res[p.Name] = true
+				}
+			}
+			return res
+		}
+	}
+
+	idx.filesOnce.Do(func() {
+		idx.files = map[string][]string{}
+		if len(idx.required) == 0 {
+			return
+		}
+
+		for _, repo := range idx.cache.repos {
+			if repo.Ignore {
+				continue
+			}
+
+			// The files are only informative here, so errors are ignored
+			ReadFilesFile(repo.Dir+"/files.xml.lzma", func(fileName, path string) bool {
+				if idx.required[path] {
+					idx.files[fileName] = append(idx.files[fileName], path)
+				}
+				return true
+			})
+		}
+	})
+
+	for _, f := range idx.files[pkg.FileName] {
+		res[f] = true
+	}
+	return res
+}
+
+// WhatRequires returns packages which have a requirement satisfied by
+// target: the media packages and the installed packages which aren't on
+// any media. File requires are matched against the files of target. If
+// installedOnly is true, only installed packages are returned.
+func (c Cache) WhatRequires(idx *RequiresIndex, target Package, arch []string, onlyLast bool, installedOnly bool) <-chan Package {
+	files := idx.packageFiles(target)
+
+	requires := func(pkg Package) bool {
+		if pkg.Name == target.Name {
+			return false
+		}
+
+		for _, req := range pkg.Requires {
+			if target.ProvidesDep(req) || (req.Flags == "" && files[req.Name]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	media := c.search(nil, arch, onlyLast, func(pkg Package) bool {
+		if installedOnly && !pkg.IsInstalled() {
+			return false
+		}
+		return requires(pkg)
+	})
+
+	out := make(chan Package)
+	go func() {
+		defer close(out)
+
+		key := func(pkg Package) string {
+			return pkg.Name + "-" + pkg.FullVersion() + "." + pkg.Arch
+		}
+
+		seen := map[string]bool{}
+		for pkg := range media {
+			seen[key(pkg)] = true
+			out <- pkg
+		}
+
+		for _, pkg := range idx.Installed {
+			if seen[key(pkg)] || (arch != nil && !compareArch(arch, pkg)) {
+				continue
+			}
+
+			if requires(pkg) {
+				seen[key(pkg)] = true
+				out <- pkg
+			}
+		}
+	}()
+
+	return out
+}
+
+// WhatProvides returns packages which provide dep. Queries starting with
+// "/" are also looked up in the files.xml.lzma of the enabled media.
+func (c Cache) WhatProvides(dep Dependency, arch []string, onlyLast bool) (<-chan Package, error) {
+	files := map[string]bool{}
+
+	if strings.HasPrefix(dep.Name, "/") && dep.Flags == "" {
+		for _, repo := range c.repos {
+			if repo.Ignore {
+				continue
+			}
+
+			err := ReadFilesFile(repo.Dir+"/files.xml.lzma", func(fileName, path string) bool {
+				if path == dep.Name {
+					files[fileName] = true
+				}
+				return true
+			})
+
+			if err != nil {
+				return nil, fmt.Errorf("Can't read files file %s: %v", repo.Dir+"/files.xml.lzma", err)
+			}
+		}
+	}
+
+	return c.search([]string{dep.Name}, arch, onlyLast, func(pkg Package) bool {
+		return files[pkg.FileName] || pkg.ProvidesDep(dep)
+	}), nil
+}
+
+// search returns the packages accepted by match. A nil arch accepts all
+// architectures.
+func (c Cache) search(names []string, arch []string, onlyLast bool, match func(Package) bool) <-chan Package {
 	out := make(chan Package)
 
 	prevName := ""
@@ -219,11 +522,11 @@ func (c Cache) SearchByName(names []string, arch []string, onlyLast bool) <-chan
 		defer close(out)
 
 		for _, pkg := range c.packages {
-			if !compareArch(arch, pkg) {
+			if arch != nil && !compareArch(arch, pkg) {
 				continue
 			}
 
-			if !compareName(names, pkg) {
+			if !match(pkg) {
 				continue
 			}
 
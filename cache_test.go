@@ -9,10 +9,12 @@ import (
 	"code.google.com/p/lzma"
 	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -146,8 +148,22 @@ func createUrpmiConfig(t *testing.T, tmpDir string, repos []Repository) {
 	}
 }
 
-func createPkgFiles(t *testing.T, tmpDir string, repoName string, pkgs []Package) {
+func createPkgFiles(t testing.TB, tmpDir string, repoName string, pkgs []Package) {
 	dir := tmpDir + "/var/" + repoName
+	writePkgFiles(t, dir, pkgs)
+
+	f, err := os.Create(dir + "/MD5SUM")
+	if err != nil {
+		t.Fatal("Can't ceate MD5SUM file:", err)
+	}
+	defer f.Close()
+
+	for _, name := range []string{"info.xml.lzma", "synthesis.hdlist.cz"} {
+		fmt.Fprintf(f, "%s  %s\n", fileMd5(dir+"/"+name), name)
+	}
+}
+
+func writePkgFiles(t testing.TB, dir string, pkgs []Package) {
 	if err := os.MkdirAll(dir, 0777); err != nil {
 		t.Error("Can't ceate info.xml.lzma file:", err)
 		t.Fail()
@@ -191,10 +207,29 @@ func createPkgFiles(t *testing.T, tmpDir string, repoName string, pkgs []Package
 	gz := gzip.NewWriter(f)
 	defer gz.Close()
 	for _, p := range pkgs {
+		writeSynthesisDeps(gz, "provides", p.Provides)
+		writeSynthesisDeps(gz, "requires", p.Requires)
+		writeSynthesisDeps(gz, "conflicts", p.Conflicts)
+		writeSynthesisDeps(gz, "obsoletes", p.Obsoletes)
 		gz.Write([]byte(fmt.Sprintf("@summary@%s\n", p.Summary)))
 		gz.Write([]byte(fmt.Sprintf("@filesize@%d\n", p.Size)))
-		gz.Write([]byte(fmt.Sprintf("@info@%s@0@12345@%s@rosa@2014.1\n", p.FileName, p.Group)))
+		gz.Write([]byte(fmt.Sprintf("@info@%s@%d@12345@%s@rosa@2014.1\n", p.FileName, p.Epoch, p.Group)))
+	}
+}
+
+func writeSynthesisDeps(w io.Writer, tag string, deps []Dependency) {
+	if len(deps) == 0 {
+		return
+	}
+
+	w.Write([]byte("@" + tag))
+	for _, d := range deps {
+		w.Write([]byte("@" + d.Name))
+		if d.Flags != "" {
+			w.Write([]byte(fmt.Sprintf("[%s %s]", d.Flags, d.EVR)))
+		}
 	}
+	w.Write([]byte("\n"))
 }
 
 func TestCompareName(t *testing.T) {
@@ -334,6 +369,8 @@ func TestSearch(t *testing.T) {
 
 	EtcDir = dir + "/etc"
 	VarDir = dir + "/var"
+	IndexCacheDir = dir + "/cache"
+	RpmDbDir = dir + "/rpm"
 
 	// ******************************************
 	createUrpmiConfig(t, dir, []Repository{
@@ -358,7 +395,10 @@ func TestSearch(t *testing.T) {
 		boomaga_x32_071,
 	})
 
-	cache := NewCache()
+	cache, err := NewCache(CacheOptions{})
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
 
 	for _, c := range cases {
 		out := cache.SearchByName(c.query, c.arch, c.onlyLast)
@@ -377,3 +417,337 @@ func TestSearch(t *testing.T) {
 	}
 
 }
+
+func TestWhatProvides(t *testing.T) {
+	libfoo_x64_12 := Package{
+		FileName: "lib64foo1-1.2-1-rosa2014.1.x86_64",
+		Provides: []Dependency{
+			{Name: "libfoo.so.1()(64bit)"},
+			{Name: "lib64foo1", Flags: "==", EVR: "1.2-1:2014.1"},
+		},
+	}
+
+	libfoo_x64_20 := Package{
+		FileName: "lib64foo2-2.0-1-rosa2014.1.x86_64",
+		Provides: []Dependency{
+			{Name: "libfoo.so.2()(64bit)"},
+			{Name: "libfoo", Flags: "==", EVR: "2.0"},
+		},
+	}
+
+	perl_foo := Package{
+		FileName: "perl-Foo-Bar-0.5-1-rosa2014.1.noarch",
+		Provides: []Dependency{
+			{Name: "perl(Foo::Bar)", Flags: "==", EVR: "0.5"},
+		},
+	}
+
+	cases := []struct {
+		query  string
+		expect []string
+	}{
+		{"libfoo.so.1()(64bit)", []string{libfoo_x64_12.FileName}},
+		{"libfoo.so.3()(64bit)", []string{}},
+		{"perl(Foo::Bar)", []string{perl_foo.FileName}},
+		{"perl(Foo::Bar) >= 0.4", []string{perl_foo.FileName}},
+		{"perl(Foo::Bar) > 0.5", []string{}},
+		{"libfoo >= 1.2", []string{libfoo_x64_20.FileName}},
+		{"libfoo < 2.0", []string{}},
+		{"lib64foo1 = 1.2", []string{libfoo_x64_12.FileName}},
+		{"lib64foo2", []string{libfoo_x64_20.FileName}},
+	}
+
+	dir, err := createDirs()
+	if err != nil {
+		t.Error("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	EtcDir = dir + "/etc"
+	VarDir = dir + "/var"
+	IndexCacheDir = dir + "/cache"
+	RpmDbDir = dir + "/rpm"
+
+	createUrpmiConfig(t, dir, []Repository{
+		{Name: "Test", URL: "http://test.com/test"},
+	})
+
+	createPkgFiles(t, dir, "Test", []Package{
+		libfoo_x64_12,
+		libfoo_x64_20,
+		perl_foo,
+	})
+
+	cache, err := NewCache(CacheOptions{})
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+
+	for _, c := range cases {
+		dep, err := ParseDependency(c.query)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.query)
+			continue
+		}
+
+		out, err := cache.WhatProvides(dep, []string{"x86_64", "noarch"}, true)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.query)
+			continue
+		}
+
+		res := []string{}
+		for p := range out {
+			res = append(res, p.FileName)
+		}
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.query, c.expect, res)
+		}
+	}
+}
+
+func TestWhatRequires(t *testing.T) {
+	libfoo := Package{
+		FileName: "lib64foo1-1.2-1-rosa2014.1.x86_64",
+		Provides: []Dependency{
+			{Name: "libfoo.so.1()(64bit)"},
+		},
+	}
+
+	foo := Package{
+		FileName: "foo-1.2-1-rosa2014.1.x86_64",
+		Requires: []Dependency{
+			{Name: "libfoo.so.1()(64bit)"},
+		},
+	}
+
+	fooPlugins := Package{
+		FileName: "foo-plugins-1.2-1-rosa2014.1.x86_64",
+		Requires: []Dependency{
+			{Name: "foo", Flags: ">=", EVR: "1.0"},
+		},
+	}
+
+	fooOld := Package{
+		FileName: "foo-old-plugins-0.1-1-rosa2014.1.x86_64",
+		Requires: []Dependency{
+			{Name: "foo", Flags: "<", EVR: "1.0"},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		expect []string
+	}{
+		{"lib64foo1", []string{foo.FileName}},
+		{"foo", []string{fooPlugins.FileName}},
+		{"foo-plugins", []string{}},
+	}
+
+	dir, err := createDirs()
+	if err != nil {
+		t.Error("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	EtcDir = dir + "/etc"
+	VarDir = dir + "/var"
+	IndexCacheDir = dir + "/cache"
+	RpmDbDir = dir + "/rpm"
+
+	createUrpmiConfig(t, dir, []Repository{
+		{Name: "Test", URL: "http://test.com/test"},
+	})
+
+	createPkgFiles(t, dir, "Test", []Package{libfoo, foo, fooPlugins, fooOld})
+
+	cache, err := NewCache(CacheOptions{})
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+	arch := []string{"x86_64", "noarch"}
+
+	for _, c := range cases {
+		res := []string{}
+		for target := range cache.SearchExact([]string{c.name}, arch, true) {
+			for p := range cache.WhatRequires(cache.NewRequiresIndex(), target, arch, true, false) {
+				res = append(res, p.FileName)
+			}
+		}
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.name, c.expect, res)
+		}
+	}
+}
+
+func TestWhatRequiresInstalled(t *testing.T) {
+	cache := &Cache{installed: map[string][]NEVRA{
+		"bash":         {{Name: "bash", Version: "4.3-1", Arch: "x86_64"}},
+		"local-script": {{Name: "local-script", Version: "1.0-1", Arch: "noarch"}},
+		"sh-tool":      {{Name: "sh-tool", Version: "1.0-1", Arch: "noarch"}},
+	}}
+
+	cache.packages = Packages{
+		{Name: "bash", Version: "4.3-1", Arch: "x86_64", Repository: "Main",
+			Installed: cache.installed["bash"]},
+		{Name: "configure-helper", Version: "1.0-1", Arch: "noarch", Repository: "Main",
+			Requires: []Dependency{{Name: "/bin/sh"}}},
+		{Name: "sh-tool", Version: "1.0-1", Arch: "noarch", Repository: "Main",
+			Installed: cache.installed["sh-tool"], Requires: []Dependency{{Name: "/bin/sh"}}},
+	}
+
+	cache.installedPkgs = Packages{
+		{Name: "bash", Version: "4.3-1", Arch: "x86_64",
+			Provides: []Dependency{{Name: "bash", Flags: "==", EVR: "4.3-1"}, {Name: "/bin/bash"}, {Name: "/bin/sh"}}},
+		{Name: "local-script", Version: "1.0-1", Arch: "noarch",
+			Requires: []Dependency{{Name: "/bin/bash"}}},
+		{Name: "sh-tool", Version: "1.0-1", Arch: "noarch",
+			Requires: []Dependency{{Name: "/bin/sh"}}},
+	}
+
+	arch := []string{"x86_64", "noarch"}
+	cases := []struct {
+		installedOnly bool
+		expect        []string
+	}{
+		{false, []string{"configure-helper", "sh-tool", "local-script"}},
+		{true, []string{"sh-tool", "local-script"}},
+	}
+
+	// The installed package which is on the media is returned once
+	idx := cache.NewRequiresIndex()
+	for _, c := range cases {
+		res := []string{}
+		for p := range cache.WhatRequires(idx, cache.packages[0], arch, true, c.installedOnly) {
+			res = append(res, p.Name)
+		}
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.installedOnly, c.expect, res)
+		}
+	}
+}
+
+func TestCacheInfo(t *testing.T) {
+	dir, err := createDirs()
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	EtcDir = dir + "/etc"
+	VarDir = dir + "/var"
+	IndexCacheDir = dir + "/cache"
+	RpmDbDir = dir + "/rpm"
+
+	createUrpmiConfig(t, dir, []Repository{
+		{Name: "Test", URL: "http://test.com/test"},
+	})
+
+	createPkgFiles(t, dir, "Test", []Package{
+		saxpath_103,
+	})
+
+	find := func(cache *Cache) Package {
+		for p := range cache.SearchExact([]string{"saxpath"}, []string{"noarch"}, true) {
+			return p
+		}
+		t.Fatalf("Package %s not found", saxpath_103.FileName)
+		return Package{}
+	}
+
+	// info.xml.lzma is loaded on demand
+	cache, err := NewCache(CacheOptions{})
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+	pkg := find(cache)
+	if pkg.URL != "" {
+		t.Errorf(TMPL_MISMATCH, "URL without Info", "", pkg.URL)
+	}
+
+	pkg, err = cache.WithInfo(pkg)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, pkg.FileName)
+	}
+
+	if pkg.URL != saxpath_103.URL || pkg.Sourcerpm != saxpath_103.Sourcerpm {
+		t.Errorf(TMPL_MISMATCH, "WithInfo", saxpath_103.URL, pkg.URL)
+	}
+
+	// info.xml.lzma is loaded by NewCache
+	cache, err = NewCache(CacheOptions{Info: true})
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+
+	pkg = find(cache)
+	if pkg.URL != saxpath_103.URL || pkg.License != saxpath_103.License {
+		t.Errorf(TMPL_MISMATCH, "URL with Info", saxpath_103.URL, pkg.URL)
+	}
+}
+
+func TestNewCacheBrokenMedia(t *testing.T) {
+	dir, err := createDirs()
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	EtcDir = dir + "/etc"
+	VarDir = dir + "/var"
+	IndexCacheDir = dir + "/cache"
+	RpmDbDir = dir + "/rpm"
+
+	createUrpmiConfig(t, dir, []Repository{
+		{Name: "Good", URL: "http://test.com/good"},
+		{Name: "Corrupt", URL: "http://test.com/corrupt"},
+		{Name: "Truncated", URL: "http://test.com/truncated"},
+	})
+
+	createPkgFiles(t, dir, "Good", []Package{saxpath_103})
+	createPkgFiles(t, dir, "Corrupt", []Package{flacon_x64_120})
+	createPkgFiles(t, dir, "Truncated", []Package{boomaga_x64_071})
+
+	// Corrupt file with the correct MD5SUM
+	corrupt := dir + "/var/Corrupt"
+	ioutil.WriteFile(corrupt+"/synthesis.hdlist.cz", []byte("not a gzip file"), 0644)
+	ioutil.WriteFile(corrupt+"/MD5SUM", []byte(fileMd5(corrupt+"/synthesis.hdlist.cz")+"  synthesis.hdlist.cz\n"), 0644)
+
+	// Half-downloaded file
+	truncated := dir + "/var/Truncated/synthesis.hdlist.cz"
+	data, _ := ioutil.ReadFile(truncated)
+	ioutil.WriteFile(truncated, data[:len(data)/2], 0644)
+
+	_, err = NewCache(CacheOptions{})
+	errs, ok := err.(MediaErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf(TMPL_MISMATCH, "errors", "2 media errors", err)
+	}
+
+	for _, name := range []string{"Corrupt", "Truncated"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf(TMPL_MISMATCH, "broken media", name, err)
+		}
+	}
+
+	cache, err := NewCache(CacheOptions{SkipBrokenMedia: true})
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "SkipBrokenMedia")
+	}
+
+	if len(cache.Warnings()) != 2 {
+		t.Errorf(TMPL_MISMATCH, "warnings", 2, cache.Warnings())
+	}
+
+	res := []string{}
+	for p := range cache.SearchByName([]string{"*"}, []string{"x86_64", "noarch"}, false) {
+		res = append(res, p.FileName)
+	}
+
+	if expect := []string{saxpath_103.FileName}; !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "packages", expect, res)
+	}
+}
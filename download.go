@@ -0,0 +1,371 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+var (
+	DownloadRetries    = 3
+	DownloadRetryDelay = time.Second
+)
+
+// Downloader fetches package files from their media into Dest. Partially
+// downloaded files are kept as FILE.part and resumed with HTTP range
+// requests. Every file is checked against the size from the media and
+// its signature before it is moved into place.
+type Downloader struct {
+	Dest        string
+	MaxParallel int
+	Keyring     openpgp.KeyRing
+	Progress    bool
+
+	progress downloadProgress
+	outMutex sync.Mutex
+}
+
+// downloadProgress is the combined state of all downloads.
+type downloadProgress struct {
+	total     int64
+	done      int64
+	files     int32
+	filesDone int32
+}
+
+// progressWriter counts the bytes written through it. The package counter
+// is used to fix the total when the package is done.
+type progressWriter struct {
+	w    io.Writer
+	p    *downloadProgress
+	item *int64
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	atomic.AddInt64(&w.p.done, int64(n))
+	atomic.AddInt64(w.item, int64(n))
+	return n, err
+}
+
+// Download fetches the packages. repos is used to find the media URLs of
+// the packages. The errors for all failed packages are returned.
+func (d *Downloader) Download(pkgs Packages, repos []Repository) []error {
+	if err := os.MkdirAll(d.Dest, 0755); err != nil {
+		return []error{err}
+	}
+
+	reps := map[string]Repository{}
+	for _, r := range repos {
+		reps[r.Name] = r
+	}
+
+	d.progress = downloadProgress{files: int32(len(pkgs))}
+	for _, pkg := range pkgs {
+		d.progress.total += int64(pkg.RPMSize)
+	}
+
+	parallel := d.MaxParallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	queue := make(chan Package)
+	var errs []error
+	var errsMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pkg := range queue {
+				err := d.downloadPackage(pkg, reps)
+				if err != nil {
+					err = fmt.Errorf("Can't download %s: %v", pkg.RPMFileName(), err)
+					errsMutex.Lock()
+					errs = append(errs, err)
+					errsMutex.Unlock()
+				}
+
+				atomic.AddInt32(&d.progress.filesDone, 1)
+				if err != nil {
+					d.printf("%v\n", err)
+				} else {
+					d.printf("%s\n", pkg.RPMFileName())
+				}
+			}
+		}()
+	}
+
+	stop := make(chan bool)
+	stopped := make(chan bool)
+	go d.showProgress(stop, stopped)
+
+	for _, pkg := range pkgs {
+		queue <- pkg
+	}
+	close(queue)
+	wg.Wait()
+
+	close(stop)
+	<-stopped
+
+	return errs
+}
+
+// printf prints a line above the progress bar.
+func (d *Downloader) printf(format string, a ...interface{}) {
+	d.outMutex.Lock()
+	defer d.outMutex.Unlock()
+
+	if d.Progress {
+		fmt.Print("\r\x1b[K")
+	}
+	fmt.Printf(format, a...)
+	if d.Progress {
+		d.printProgress()
+	}
+}
+
+func (d *Downloader) showProgress(stop <-chan bool, stopped chan<- bool) {
+	defer close(stopped)
+	if !d.Progress {
+		<-stop
+		return
+	}
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			d.outMutex.Lock()
+			d.printProgress()
+			fmt.Println("")
+			d.outMutex.Unlock()
+			return
+
+		case <-ticker.C:
+			d.outMutex.Lock()
+			d.printProgress()
+			d.outMutex.Unlock()
+		}
+	}
+}
+
+func (d *Downloader) printProgress() {
+	const width = 30
+
+	total := atomic.LoadInt64(&d.progress.total)
+	done := atomic.LoadInt64(&d.progress.done)
+	percent := int64(100)
+	if total > 0 {
+		percent = done * 100 / total
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	bar := strings.Repeat("=", int(percent*width/100))
+	bar += strings.Repeat(" ", width-len(bar))
+	fmt.Printf("\r\x1b[K[%s] %3d%% %s / %s  %d/%d files", bar, percent,
+		humanSize(done), humanSize(total),
+		atomic.LoadInt32(&d.progress.filesDone), atomic.LoadInt32(&d.progress.files))
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1024*1024*1024))
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// downloadPackage downloads a single package trying all mirrors of its
+// media.
+func (d *Downloader) downloadPackage(pkg Package, reps map[string]Repository) error {
+	// Replace the bytes counted while downloading with the package size
+	var counted int64
+	defer func() {
+		atomic.AddInt64(&d.progress.done, int64(pkg.RPMSize)-atomic.LoadInt64(&counted))
+	}()
+
+	rep, ok := reps[pkg.Repository]
+	if !ok {
+		return fmt.Errorf("media %s not found", pkg.Repository)
+	}
+
+	file := d.Dest + "/" + pkg.RPMFileName()
+	if stat, err := os.Stat(file); err == nil && (pkg.RPMSize == 0 || stat.Size() == int64(pkg.RPMSize)) {
+		if _, err := VerifyRPMSignature(file, d.Keyring); err == nil {
+			return nil
+		}
+	}
+
+	part := file + ".part"
+	_, err := rep.WithMirrors(func(baseURL string) error {
+		return d.downloadFrom(strings.TrimRight(baseURL, "/")+"/"+pkg.RPMFileName(), part, pkg, &counted)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(part, file)
+}
+
+// downloadFrom downloads the file from the URL with retries and checks
+// the result.
+func (d *Downloader) downloadFrom(url string, part string, pkg Package, counted *int64) error {
+	var err error
+	delay := DownloadRetryDelay
+
+	for i := 0; i < DownloadRetries; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		if err = d.fetch(url, part, counted); err != nil {
+			continue
+		}
+
+		err = checkDownloadedSize(part, pkg)
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// A bad signature won't be fixed by downloading again from the same
+	// mirror.
+	if _, err := VerifyRPMSignature(part, d.Keyring); err != nil {
+		os.Remove(part)
+		return err
+	}
+
+	return nil
+}
+
+// checkDownloadedSize compares the file size with the size from the media.
+// Larger files can't be resumed and are removed.
+func checkDownloadedSize(file string, pkg Package) error {
+	if pkg.RPMSize == 0 {
+		return nil
+	}
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() > int64(pkg.RPMSize) {
+		os.Remove(file)
+	}
+
+	if stat.Size() != int64(pkg.RPMSize) {
+		return fmt.Errorf("size mismatch: expected %v, got %v", pkg.RPMSize, stat.Size())
+	}
+	return nil
+}
+
+// fetch downloads url into part. For HTTP(S) the download continues from
+// the end of the existing part file.
+func (d *Downloader) fetch(rawurl string, part string, counted *int64) error {
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	// The bytes counted for this package are dropped together with the
+	// part file.
+	restart := func() error {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		atomic.AddInt64(&d.progress.done, -atomic.SwapInt64(counted, 0))
+		_, err := f.Seek(0, io.SeekStart)
+		return err
+	}
+
+	var r io.ReadCloser
+	if u, err := url.Parse(rawurl); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		req, err := http.NewRequest("GET", rawurl, nil)
+		if err != nil {
+			return err
+		}
+
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+
+		case http.StatusOK:
+			if err := restart(); err != nil {
+				resp.Body.Close()
+				return err
+			}
+
+		// The part file is already complete
+		case http.StatusRequestedRangeNotSatisfiable:
+			resp.Body.Close()
+			return nil
+
+		default:
+			resp.Body.Close()
+			return fmt.Errorf("%s: %s", rawurl, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		if err := restart(); err != nil {
+			return err
+		}
+
+		r, err = OpenURL(rawurl)
+		if err != nil {
+			return err
+		}
+	}
+	defer r.Close()
+
+	if _, err := io.Copy(progressWriter{f, &d.progress, counted}, r); err != nil {
+		return fmt.Errorf("%s: %v", rawurl, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestDownloader(t *testing.T) {
+	key := newTestKey(t)
+	other := newTestKey(t)
+
+	files := map[string][]byte{
+		"good-1.0-1.x86_64.rpm":     buildSignedTestRPM(t, key, []int{RPMSIGTAG_MD5, RPMSIGTAG_PGP}, bytes.Repeat([]byte("payload"), 1000)),
+		"resumed-1.0-1.x86_64.rpm":  buildSignedTestRPM(t, key, []int{RPMSIGTAG_MD5, RPMSIGTAG_PGP}, bytes.Repeat([]byte("resumed"), 1000)),
+		"flaky-1.0-1.x86_64.rpm":    buildSignedTestRPM(t, key, []int{RPMSIGTAG_MD5, RPMSIGTAG_PGP}, []byte("flaky")),
+		"unsigned-1.0-1.x86_64.rpm": buildSignedTestRPM(t, other, []int{RPMSIGTAG_MD5, RPMSIGTAG_PGP}, []byte("unsigned")),
+		"stalled-1.0-1.x86_64.rpm":  buildSignedTestRPM(t, key, []int{RPMSIGTAG_MD5, RPMSIGTAG_PGP}, bytes.Repeat([]byte("stalled"), 1000)),
+	}
+
+	var mutex sync.Mutex
+	ranges := map[string]string{}
+	flaky := 0
+	stalled := 0
+	release := make(chan bool)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/media/")
+
+		mutex.Lock()
+		ranges[name] = r.Header.Get("Range")
+		fail := name == "flaky-1.0-1.x86_64.rpm" && flaky < 1
+		if fail {
+			flaky++
+		}
+		stall := name == "stalled-1.0-1.x86_64.rpm" && stalled < 1
+		if stall {
+			stalled++
+		}
+		mutex.Unlock()
+
+		if fail {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+
+		// The first request sends a half of the file and hangs
+		if stall {
+			data := files[name]
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			<-release
+			return
+		}
+
+		data, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Half of the file was downloaded before
+	resumed := files["resumed-1.0-1.x86_64.rpm"]
+	ioutil.WriteFile(dir+"/resumed-1.0-1.x86_64.rpm.part", resumed[:len(resumed)/2], 0644)
+
+	pkgs := Packages{}
+	for _, name := range []string{"good-1.0-1.x86_64.rpm", "resumed-1.0-1.x86_64.rpm", "flaky-1.0-1.x86_64.rpm", "unsigned-1.0-1.x86_64.rpm", "stalled-1.0-1.x86_64.rpm"} {
+		pkgs = append(pkgs, Package{FileName: strings.TrimSuffix(name, ".rpm"), RPMSize: len(files[name]), Repository: "main"})
+	}
+
+	defer func(d time.Duration) { FetchIdleTimeout = d }(FetchIdleTimeout)
+	FetchIdleTimeout = 200 * time.Millisecond
+
+	DownloadRetryDelay = time.Millisecond
+	d := &Downloader{Dest: dir, MaxParallel: 2, Keyring: openpgp.EntityList{key}}
+	errs := d.Download(pkgs, []Repository{{Name: "main", URL: srv.URL + "/media"}})
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "unsigned-1.0-1.x86_64.rpm") {
+		t.Errorf(TMPL_MISMATCH, "errors", "unsigned-1.0-1.x86_64.rpm", errs)
+	}
+
+	for _, name := range []string{"good-1.0-1.x86_64.rpm", "resumed-1.0-1.x86_64.rpm", "flaky-1.0-1.x86_64.rpm", "stalled-1.0-1.x86_64.rpm"} {
+		if res, _ := ioutil.ReadFile(dir + "/" + name); !bytes.Equal(res, files[name]) {
+			t.Errorf(TMPL_MISMATCH, name, len(files[name]), len(res))
+		}
+	}
+
+	for _, name := range []string{"unsigned-1.0-1.x86_64.rpm", "unsigned-1.0-1.x86_64.rpm.part", "resumed-1.0-1.x86_64.rpm.part"} {
+		if fileExists(dir + "/" + name) {
+			t.Errorf("%s must be removed", name)
+		}
+	}
+
+	if r := ranges["resumed-1.0-1.x86_64.rpm"]; r == "" {
+		t.Errorf("Download of resumed-1.0-1.x86_64.rpm must be resumed")
+	}
+
+	if r := ranges["stalled-1.0-1.x86_64.rpm"]; r == "" {
+		t.Errorf("Download of stalled-1.0-1.x86_64.rpm must be resumed after the timeout")
+	}
+
+	if d.progress.done != d.progress.total {
+		t.Errorf(TMPL_MISMATCH, "progress", d.progress.total, d.progress.done)
+	}
+}
+
+func TestDownloaderRestart(t *testing.T) {
+	data := bytes.Repeat([]byte("restarted"), 100)
+
+	// The server doesn't support ranges
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The part file was written by a previous attempt
+	part := dir + "/restarted.rpm.part"
+	ioutil.WriteFile(part, data[:len(data)/2], 0644)
+	counted := int64(len(data) / 2)
+
+	d := &Downloader{Dest: dir}
+	d.progress.done = counted
+	if err := d.fetch(srv.URL+"/restarted.rpm", part, &counted); err != nil {
+		t.Fatalf(TMPL_ERROR, err, srv.URL)
+	}
+
+	if res, _ := ioutil.ReadFile(part); !bytes.Equal(res, data) {
+		t.Errorf(TMPL_MISMATCH, "part", len(data), len(res))
+	}
+
+	if counted != int64(len(data)) {
+		t.Errorf(TMPL_MISMATCH, "counted", len(data), counted)
+	}
+
+	if d.progress.done != int64(len(data)) {
+		t.Errorf(TMPL_MISMATCH, "progress", len(data), d.progress.done)
+	}
+}
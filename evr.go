@@ -0,0 +1,228 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// EVR is a parsed "[epoch:]version[-release][:distepoch]" string.
+type EVR struct {
+	Epoch     int
+	Version   string
+	Release   string
+	Distepoch string
+}
+
+// ParseEVR parses "[epoch:]version[-release][:distepoch]". A missing epoch
+// is treated as 0.
+func ParseEVR(s string) EVR {
+	res := EVR{}
+
+	// The epoch is a number, "1.0:2014.1" is a version with a distepoch
+	if n := strings.Index(s, ":"); n > -1 && n < strings.IndexAny(s+"-", "-") {
+		if isDigits(s[:n]) {
+			res.Epoch, _ = strconv.Atoi(s[:n])
+			s = s[n+1:]
+		}
+	}
+
+	if n := strings.LastIndex(s, ":"); n > -1 {
+		res.Distepoch = s[n+1:]
+		s = s[:n]
+	}
+
+	res.Version = s
+	if n := strings.LastIndex(s, "-"); n > -1 {
+		res.Version = s[:n]
+		res.Release = s[n+1:]
+	}
+
+	return res
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func (e EVR) String() string {
+	res := e.Version
+	if e.Epoch != 0 {
+		res = strconv.Itoa(e.Epoch) + ":" + res
+	}
+
+	if e.Release != "" {
+		res += "-" + e.Release
+	}
+
+	if e.Distepoch != "" {
+		res += ":" + e.Distepoch
+	}
+
+	return res
+}
+
+// Compare compares two EVRs like rpm does. The release and the distepoch
+// are only compared when both sides have them, so "1.0" matches any
+// release of 1.0.
+func (e EVR) Compare(o EVR) int {
+	if e.Epoch != o.Epoch {
+		if e.Epoch < o.Epoch {
+			return -1
+		}
+		return 1
+	}
+
+	if res := rpmvercmp(e.Version, o.Version); res != 0 {
+		return res
+	}
+
+	if e.Release != "" && o.Release != "" {
+		if res := rpmvercmp(e.Release, o.Release); res != 0 {
+			return res
+		}
+	}
+
+	if e.Distepoch != "" && o.Distepoch != "" {
+		return rpmvercmp(e.Distepoch, o.Distepoch)
+	}
+
+	return 0
+}
+
+// CompareEVR parses and compares two EVR strings.
+func CompareEVR(evr1, evr2 string) int {
+	return ParseEVR(evr1).Compare(ParseEVR(evr2))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// rpmvercmp compares two version or release strings with the same rules
+// as rpmvercmp() from librpm: the strings are split into alternating
+// numeric and alphabetic segments, numeric segments are newer than
+// alphabetic ones, "~" sorts before anything and "^" sorts after the
+// base version but before any other continuation.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isDigit(a[i]) && !isAlpha(a[i]) && a[i] != '~' && a[i] != '^' {
+			i++
+		}
+		for j < len(b) && !isDigit(b[j]) && !isAlpha(b[j]) && b[j] != '~' && b[j] != '^' {
+			j++
+		}
+
+		// Tilde sorts before everything else
+		aTilde := i < len(a) && a[i] == '~'
+		bTilde := j < len(b) && b[j] == '~'
+		if aTilde || bTilde {
+			if !aTilde {
+				return 1
+			}
+			if !bTilde {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+
+		// Caret sorts after the end of string, but before anything else
+		aCaret := i < len(a) && a[i] == '^'
+		bCaret := j < len(b) && b[j] == '^'
+		if aCaret || bCaret {
+			if i >= len(a) {
+				return -1
+			}
+			if j >= len(b) {
+				return 1
+			}
+			if !aCaret {
+				return 1
+			}
+			if !bCaret {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		si, sj := i, j
+		isNum := isDigit(a[i])
+		if isNum {
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+		} else {
+			for i < len(a) && isAlpha(a[i]) {
+				i++
+			}
+			for j < len(b) && isAlpha(b[j]) {
+				j++
+			}
+		}
+
+		seg1 := a[si:i]
+		seg2 := b[sj:j]
+
+		// Segments of different types: numeric is newer than alphabetic
+		if seg2 == "" {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		if isNum {
+			seg1 = strings.TrimLeft(seg1, "0")
+			seg2 = strings.TrimLeft(seg2, "0")
+
+			if len(seg1) != len(seg2) {
+				if len(seg1) > len(seg2) {
+					return 1
+				}
+				return -1
+			}
+		}
+
+		if res := strings.Compare(seg1, seg2); res != 0 {
+			return res
+		}
+	}
+
+	switch {
+	case i >= len(a) && j >= len(b):
+		return 0
+	case i >= len(a):
+		return -1
+	default:
+		return 1
+	}
+}
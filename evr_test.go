@@ -0,0 +1,204 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+// Test vectors are taken from rpm's tests/rpmvercmp.at.
+func TestRpmvercmp(t *testing.T) {
+	cases := []struct {
+		ver1   string
+		ver2   string
+		expect int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0", "1.0", 1},
+
+		{"2.0.1", "2.0.1", 0},
+		{"2.0", "2.0.1", -1},
+		{"2.0.1", "2.0", 1},
+
+		{"2.0.1a", "2.0.1a", 0},
+		{"2.0.1a", "2.0.1", 1},
+		{"2.0.1", "2.0.1a", -1},
+
+		{"5.5p1", "5.5p1", 0},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p2", "5.5p1", 1},
+
+		{"5.5p10", "5.5p10", 0},
+		{"5.5p1", "5.5p10", -1},
+		{"5.5p10", "5.5p1", 1},
+
+		{"10xyz", "10.1xyz", -1},
+		{"10.1xyz", "10xyz", 1},
+
+		{"xyz10", "xyz10", 0},
+		{"xyz10", "xyz10.1", -1},
+		{"xyz10.1", "xyz10", 1},
+
+		{"xyz.4", "xyz.4", 0},
+		{"xyz.4", "8", -1},
+		{"8", "xyz.4", 1},
+		{"xyz.4", "2", -1},
+		{"2", "xyz.4", 1},
+
+		{"5.5p2", "5.6p1", -1},
+		{"5.6p1", "5.5p2", 1},
+
+		{"5.6p1", "6.5p1", -1},
+		{"6.5p1", "5.6p1", 1},
+
+		{"6.0.rc1", "6.0", 1},
+		{"6.0", "6.0.rc1", -1},
+
+		{"10b2", "10a1", 1},
+		{"10a2", "10b2", -1},
+
+		{"1.0aa", "1.0aa", 0},
+		{"1.0a", "1.0aa", -1},
+		{"1.0aa", "1.0a", 1},
+
+		{"10.0001", "10.0001", 0},
+		{"10.0001", "10.1", 0},
+		{"10.1", "10.0001", 0},
+		{"10.0001", "10.0039", -1},
+		{"10.0039", "10.0001", 1},
+
+		{"4.999.9", "5.0", -1},
+		{"5.0", "4.999.9", 1},
+
+		{"20101121", "20101121", 0},
+		{"20101121", "20101122", -1},
+		{"20101122", "20101121", 1},
+
+		{"2_0", "2_0", 0},
+		{"2.0", "2_0", 0},
+		{"2_0", "2.0", 0},
+
+		{"a", "a", 0},
+		{"a+", "a+", 0},
+		{"a+", "a_", 0},
+		{"a_", "a+", 0},
+		{"+a", "+a", 0},
+		{"+a", "_a", 0},
+		{"_a", "+a", 0},
+		{"+_", "+_", 0},
+		{"_+", "+_", 0},
+		{"_+", "_+", 0},
+		{"+", "_", 0},
+		{"_", "+", 0},
+
+		{"1.0~rc1", "1.0~rc1", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0~rc1", 1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc2", "1.0~rc1", 1},
+		{"1.0~rc1~git123", "1.0~rc1~git123", 0},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0~rc1", "1.0~rc1~git123", 1},
+
+		{"1.0^", "1.0^", 0},
+		{"1.0^", "1.0", 1},
+		{"1.0", "1.0^", -1},
+		{"1.0^git1", "1.0^git1", 0},
+		{"1.0^git1", "1.0", 1},
+		{"1.0", "1.0^git1", -1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0^git2", "1.0^git1", 1},
+		{"1.0^git1", "1.01", -1},
+		{"1.01", "1.0^git1", 1},
+		{"1.0^20160101", "1.0^20160101", 0},
+		{"1.0^20160101", "1.0.1", -1},
+		{"1.0.1", "1.0^20160101", 1},
+		{"1.0^20160101^git1", "1.0^20160101^git1", 0},
+		{"1.0^20160102", "1.0^20160101^git1", 1},
+		{"1.0^20160101^git1", "1.0^20160102", -1},
+
+		{"1.0~rc1^git1", "1.0~rc1^git1", 0},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1.0~rc1", "1.0~rc1^git1", -1},
+
+		{"1.0^git1~pre", "1.0^git1~pre", 0},
+		{"1.0^git1", "1.0^git1~pre", 1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+
+		{"1.0rc1", "1.0", 1},
+		{"0.10.0", "0.9.9", 1},
+		{"git.0.2", "git.0.1.1", 1},
+	}
+
+	for _, c := range cases {
+		res := rpmvercmp(c.ver1, c.ver2)
+
+		if res != c.expect {
+			t.Errorf(`Result mismatch
+------------------------
+ver1: %#v
+ver2: %#v
+expected: %v
+got:      %v`,
+				c.ver1,
+				c.ver2,
+				c.expect, res)
+		}
+	}
+}
+
+func TestParseEVR(t *testing.T) {
+	cases := []struct {
+		str    string
+		expect EVR
+	}{
+		{"1.0", EVR{Version: "1.0"}},
+		{"1.0-1", EVR{Version: "1.0", Release: "1"}},
+		{"2:1.0-1", EVR{Epoch: 2, Version: "1.0", Release: "1"}},
+		{"0.1.2-1:2014.1", EVR{Version: "0.1.2", Release: "1", Distepoch: "2014.1"}},
+		{"1:0.1.2-1:2014.1", EVR{Epoch: 1, Version: "0.1.2", Release: "1", Distepoch: "2014.1"}},
+		{"1.0-0.rc1.1", EVR{Version: "1.0", Release: "0.rc1.1"}},
+		{"1.0:2014.1", EVR{Version: "1.0", Distepoch: "2014.1"}},
+		{"3:1.0:2014.1", EVR{Epoch: 3, Version: "1.0", Distepoch: "2014.1"}},
+	}
+
+	for _, c := range cases {
+		res := ParseEVR(c.str)
+		if res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.str, c.expect, res)
+		}
+
+		if res.String() != c.str {
+			t.Errorf(TMPL_MISMATCH, c.str, c.str, res.String())
+		}
+	}
+}
+
+func TestCompareEVR(t *testing.T) {
+	cases := []struct {
+		evr1   string
+		evr2   string
+		expect int
+	}{
+		{"1.0-1", "1.0-1", 0},
+		{"1:1.0-1", "2.0-1", 1},
+		{"1.0-1", "1:0.5-1", -1},
+		{"0:1.0-1", "1.0-1", 0},
+		{"1.0", "1.0-5", 0},
+		{"1.0-2", "1.0-10", -1},
+		{"1.0-1:2014.1", "1.0-1:2012.1", 1},
+		{"1.0-1:2014.1", "1.0-1", 0},
+		{"1.0~rc1-1", "1.0-1", -1},
+	}
+
+	for _, c := range cases {
+		res := CompareEVR(c.evr1, c.evr2)
+		if res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.evr1+" vs "+c.evr2, c.expect, res)
+		}
+	}
+}
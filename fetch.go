@@ -0,0 +1,228 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// A stalled mirror fails after these timeouts, so the next one is
+	// tried. The idle timeout limits the time without any data, not the
+	// whole transfer.
+	FetchConnectTimeout = 30 * time.Second
+	FetchIdleTimeout    = 60 * time.Second
+)
+
+// httpClient is used for all HTTP transfers instead of http.DefaultClient,
+// which has no timeouts.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:       http.ProxyFromEnvironment,
+		DialContext: dialContext,
+	},
+}
+
+// idleTimeoutConn fails a read or write which makes no progress for the
+// timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(p)
+}
+
+func (c *idleTimeoutConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(p)
+}
+
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: FetchConnectTimeout}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &idleTimeoutConn{conn, FetchIdleTimeout}, nil
+}
+
+func dial(addr string) (net.Conn, error) {
+	return dialContext(context.Background(), "tcp", addr)
+}
+
+// OpenURL opens a remote or local file for reading. The http, https, ftp
+// and file schemes are supported, a URL without a scheme is a local path.
+func OpenURL(rawurl string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse URL %s: %v", rawurl, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		resp, err := httpClient.Get(rawurl)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Can't download %s: %s", rawurl, resp.Status)
+		}
+		return resp.Body, nil
+
+	case "ftp":
+		return openFTP(u)
+
+	case "file":
+		return os.Open(u.Path)
+
+	case "":
+		return os.Open(rawurl)
+	}
+
+	return nil, fmt.Errorf("Unsupported URL scheme %s", rawurl)
+}
+
+// ftpReader reads a file over the FTP data connection and closes the
+// control connection when done.
+type ftpReader struct {
+	data net.Conn
+	ctrl *textproto.Conn
+}
+
+func (r *ftpReader) Read(p []byte) (int, error) {
+	return r.data.Read(p)
+}
+
+func (r *ftpReader) Close() error {
+	r.data.Close()
+	_, _, err := r.ctrl.ReadResponse(2)
+	r.ctrl.Cmd("QUIT")
+	r.ctrl.Close()
+	return err
+}
+
+// openFTP retrieves a file using passive mode FTP.
+func openFTP(u *url.URL) (io.ReadCloser, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	conn, err := dial(host)
+	if err != nil {
+		return nil, err
+	}
+	ctrl := textproto.NewConn(conn)
+
+	res, err := ftpLogin(ctrl, u)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("Can't download %s: %v", u, err)
+	}
+	return res, nil
+}
+
+func ftpLogin(ctrl *textproto.Conn, u *url.URL) (io.ReadCloser, error) {
+	cmd := func(expect int, format string, args ...interface{}) (int, string, error) {
+		if format != "" {
+			if _, err := ctrl.Cmd(format, args...); err != nil {
+				return 0, "", err
+			}
+		}
+		return ctrl.ReadResponse(expect)
+	}
+
+	if _, _, err := cmd(2, ""); err != nil {
+		return nil, err
+	}
+
+	user, pass := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			pass = p
+		}
+	}
+
+	code, _, err := cmd(0, "USER %s", user)
+	if err != nil {
+		return nil, err
+	}
+
+	if code == 331 {
+		if _, _, err := cmd(2, "PASS %s", pass); err != nil {
+			return nil, err
+		}
+	} else if code/100 != 2 {
+		return nil, fmt.Errorf("login failed: %v", code)
+	}
+
+	if _, _, err := cmd(2, "TYPE I"); err != nil {
+		return nil, err
+	}
+
+	_, msg, err := cmd(227, "PASV")
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := ftpPasvPort(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the address of the control connection, the one from the PASV
+	// reply is often wrong behind NAT.
+	data, err := dial(net.JoinHostPort(u.Hostname(), strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := cmd(1, "RETR %s", u.Path); err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	return &ftpReader{data: data, ctrl: ctrl}, nil
+}
+
+// ftpPasvPort extracts the data port from a reply like
+// "Entering Passive Mode (127,0,0,1,195,80)".
+func ftpPasvPort(msg string) (int, error) {
+	start := strings.Index(msg, "(")
+	end := strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("incorrect PASV reply '%s'", msg)
+	}
+
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("incorrect PASV reply '%s'", msg)
+	}
+
+	hi, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	lo, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("incorrect PASV reply '%s'", msg)
+	}
+
+	return hi*256 + lo, nil
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOpenURLTimeout(t *testing.T) {
+	defer func(d time.Duration) { FetchIdleTimeout = d }(FetchIdleTimeout)
+	FetchIdleTimeout = 200 * time.Millisecond
+
+	release := make(chan bool)
+
+	// Sends the headers and a part of the body, then stalls
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("part"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	// Accepts the connection, but never sends the greeting
+	ftp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+
+	go func() {
+		for {
+			conn, err := ftp.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	for _, u := range []string{srv.URL + "/file", "ftp://" + ftp.Addr().String() + "/file"} {
+		start := time.Now()
+
+		r, err := OpenURL(u)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+			r.Close()
+		}
+
+		if err == nil {
+			t.Errorf("No error for the stalled server %s", u)
+		}
+
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf(TMPL_MISMATCH, u, FetchIdleTimeout, d)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"code.google.com/p/lzma"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadFilesFile reads files.xml.lzma and calls fn for every file path it
+// contains. The file looks like:
+//
+//	<files fn="boomaga-0.7.1-1-rosa2014.1.x86_64">
+//	/usr/bin/boomaga
+//	</files>
+//
+// Reading stops if fn returns false.
+func ReadFilesFile(file string, fn func(pkgFileName, path string) bool) error {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lz := lzma.NewReader(f)
+	defer lz.Close()
+
+	scanner := bufio.NewScanner(lz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	cur := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if n := strings.Index(line, "<files "); n > -1 {
+			line = line[n:]
+			b := strings.Index(line, `fn="`)
+			if b < 0 {
+				return fmt.Errorf("Can't parse %s: incorrect line '%s'", file, line)
+			}
+
+			line = line[b+4:]
+			e := strings.Index(line, `"`)
+			if e < 0 {
+				return fmt.Errorf("Can't parse %s: incorrect line '%s'", file, line)
+			}
+
+			cur = line[:e]
+			line = line[e+1:]
+			line = line[strings.Index(line, ">")+1:]
+		}
+
+		line = strings.TrimSpace(strings.Replace(line, "</files>", "", -1))
+		if cur == "" || line == "" {
+			continue
+		}
+
+		if !fn(cur, line) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,235 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+var IndexCacheDir = "/var/cache/zrpm/index"
+
+// The index is rebuilt when the format of the stored data changes.
+const indexFormat = 3
+
+// The index file starts with the magic and the format.
+const indexMagic = "ZRPMIDX"
+
+// Every media has an index for each metadata layer.
+const (
+	synthesisLayer = "synthesis"
+	infoLayer      = "info"
+)
+
+// mediaIndexHeader is stored before the data. It is checked before the
+// data is decoded.
+type mediaIndexHeader struct {
+	Format int
+	Media  string
+	Layer  string
+	Sum    string // MD5 of the MD5SUM file of the media
+}
+
+func mediaIndexFile(name string, layer string) string {
+	sum := md5.Sum([]byte(name))
+	return IndexCacheDir + "/" + hex.EncodeToString(sum[:]) + "." + layer + ".idx"
+}
+
+// mediaSum returns the key of the media metadata. MD5SUM is replaced
+// together with the media files by zrpm update, so it changes with every
+// change of them.
+func mediaSum(rep Repository) (string, error) {
+	data, err := ioutil.ReadFile(rep.Dir + "/MD5SUM")
+	if err != nil {
+		return "", err
+	}
+
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readMediaIndex returns the reader positioned at the data of the stored
+// layer, it returns false if there is no index or it is out of date.
+func readMediaIndex(rep Repository, layer string, sum string) (*indexReader, bool) {
+	if sum == "" {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(mediaIndexFile(rep.Name, layer))
+	if err != nil || !bytes.HasPrefix(data, []byte(indexMagic)) {
+		return nil, false
+	}
+
+	r := newIndexReader(data[len(indexMagic):])
+	header := mediaIndexHeader{
+		Format: r.int(),
+		Media:  r.str(),
+		Layer:  r.str(),
+		Sum:    r.str(),
+	}
+
+	if r.bad || header.Format != indexFormat || header.Media != rep.Name || header.Layer != layer || header.Sum != sum {
+		return nil, false
+	}
+
+	return r, true
+}
+
+// writeMediaIndex saves the layer of the media, the data is written by
+// write. The index is an optimization, so errors (for example for non-root
+// users) are ignored.
+func writeMediaIndex(rep Repository, layer string, sum string, write func(w *indexWriter)) {
+	if sum == "" || os.MkdirAll(IndexCacheDir, 0755) != nil {
+		return
+	}
+
+	w := &indexWriter{buf: []byte(indexMagic)}
+	w.int(indexFormat)
+	w.str(rep.Name)
+	w.str(layer)
+	w.str(sum)
+	write(w)
+
+	tmp, err := ioutil.TempFile(IndexCacheDir, ".index-")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(w.buf)
+	tmp.Close()
+	if err != nil || os.Rename(tmp.Name(), mediaIndexFile(rep.Name, layer)) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// removeMediaIndex deletes all index files of the media.
+func removeMediaIndex(name string) {
+	for _, layer := range []string{synthesisLayer, infoLayer} {
+		os.Remove(mediaIndexFile(name, layer))
+	}
+}
+
+// LoadMedia returns the packages from synthesis.hdlist.cz of the media.
+// The file is parsed only if the index in IndexCacheDir is missing or was
+// built for other metadata.
+func LoadMedia(rep Repository) (Packages, error) {
+	sum, _ := mediaSum(rep)
+
+	if r, ok := readMediaIndex(rep, synthesisLayer, sum); ok {
+		if pkgs, ok := decodePackages(r); ok {
+			return pkgs, nil
+		}
+	}
+
+	// Don't try to parse half-downloaded files
+	if err := verifyMediaFile(rep, "synthesis.hdlist.cz", true); err != nil {
+		return nil, err
+	}
+
+	pkgs, err := parseSynthesis(rep)
+	if err != nil {
+		return nil, err
+	}
+
+	writeMediaIndex(rep, synthesisLayer, sum, func(w *indexWriter) { encodePackages(w, pkgs) })
+	return pkgs, nil
+}
+
+// LoadMediaInfo returns the records from info.xml.lzma of the media by
+// the package file names. Like LoadMedia it uses the index if possible.
+func LoadMediaInfo(rep Repository) (map[string]InfoRecord, error) {
+	sum, _ := mediaSum(rep)
+
+	if r, ok := readMediaIndex(rep, infoLayer, sum); ok {
+		if info, ok := decodeInfo(r); ok {
+			return info, nil
+		}
+	}
+
+	// The media with "xml-info: never" have no info
+	if !fileExists(rep.Dir + "/info.xml.lzma") {
+		return map[string]InfoRecord{}, nil
+	}
+
+	if err := verifyMediaFile(rep, "info.xml.lzma", false); err != nil {
+		return nil, err
+	}
+
+	info, err := parseInfo(rep)
+	if err != nil {
+		return nil, err
+	}
+
+	writeMediaIndex(rep, infoLayer, sum, func(w *indexWriter) { encodeInfo(w, info) })
+	return info, nil
+}
+
+// verifyMediaFile checks the metadata file against MD5SUM before it is
+// parsed. The media without MD5SUM can't be verified and are loaded as is,
+// as well as the optional files which aren't listed in it.
+func verifyMediaFile(rep Repository, name string, required bool) error {
+	sums, err := rep.localSums()
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err == nil {
+		if _, ok := sums[name]; !ok && !required {
+			return nil
+		}
+
+		if errs := rep.Verify(name); len(errs) > 0 {
+			err = errs[0]
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("Media %s is broken: %v\nRun zrpm update to fix it.", rep.Name, err)
+	}
+	return nil
+}
+
+func parseSynthesis(rep Repository) (Packages, error) {
+	out := make(chan Package, 9999)
+	var err error
+	go func() {
+		defer close(out)
+		err = ReadSynthesisFile(rep, rep.Dir+"/synthesis.hdlist.cz", out)
+	}()
+
+	pkgs := Packages{}
+	for pkg := range out {
+		pkgs = append(pkgs, pkg)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't read synthesis file %s: %v", rep.Dir+"/synthesis.hdlist.cz", err)
+	}
+	return pkgs, nil
+}
+
+func parseInfo(rep Repository) (map[string]InfoRecord, error) {
+	out := make(chan InfoRecord, 9999)
+	var err error
+	go func() {
+		defer close(out)
+		err = ReadInfoFile(rep.Dir+"/info.xml.lzma", out)
+	}()
+
+	info := map[string]InfoRecord{}
+	for i := range out {
+		info[i.Filename] = i
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't read info file %s: %v", rep.Dir+"/info.xml.lzma", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,306 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadMedia(t *testing.T) {
+	dir, err := createDirs()
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	IndexCacheDir = dir + "/cache"
+	rep := Repository{Name: "Test", Dir: dir + "/var/Test"}
+
+	fileNames := func(pkgs Packages) []string {
+		res := []string{}
+		for _, p := range pkgs {
+			res = append(res, p.FileName)
+		}
+		sort.Strings(res)
+		return res
+	}
+
+	createPkgFiles(t, dir, "Test", []Package{saxpath_103, flacon_x64_120})
+	expect := []string{flacon_x64_120.FileName, saxpath_103.FileName}
+
+	pkgs, err := LoadMedia(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if res := fileNames(pkgs); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "parsed", expect, res)
+	}
+
+	info, err := LoadMediaInfo(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if res := strings.TrimSpace(info[saxpath_103.FileName].Description); res != saxpath_103.Description {
+		t.Errorf(TMPL_MISMATCH, "description", saxpath_103.Description, res)
+	}
+
+	for _, layer := range []string{synthesisLayer, infoLayer} {
+		if !fileExists(mediaIndexFile(rep.Name, layer)) {
+			t.Fatalf("Index %s for %s was not created", layer, rep.Name)
+		}
+	}
+
+	// The media files aren't read while the index is up to date
+	os.Remove(rep.Dir + "/synthesis.hdlist.cz")
+	os.Remove(rep.Dir + "/info.xml.lzma")
+
+	indexed, err := LoadMedia(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if !reflect.DeepEqual(indexed, pkgs) {
+		t.Errorf(TMPL_MISMATCH, "indexed", pkgs, indexed)
+	}
+
+	if res, err := LoadMediaInfo(rep); err != nil || !reflect.DeepEqual(res, info) {
+		t.Errorf(TMPL_MISMATCH, "indexed info", info, res)
+	}
+
+	// New metadata rebuilds the index
+	createPkgFiles(t, dir, "Test", []Package{boomaga_x64_071})
+	expect = []string{boomaga_x64_071.FileName}
+
+	pkgs, err = LoadMedia(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if res := fileNames(pkgs); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "updated", expect, res)
+	}
+}
+
+func TestLoadMediaBrokenIndex(t *testing.T) {
+	dir, err := createDirs()
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	IndexCacheDir = dir + "/cache"
+	rep := Repository{Name: "Test", Dir: dir + "/var/Test"}
+
+	createPkgFiles(t, dir, "Test", []Package{saxpath_103, flacon_x64_120})
+	pkgs, err := LoadMedia(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	// A truncated index is ignored and the media is parsed again
+	file := mediaIndexFile(rep.Name, synthesisLayer)
+	data, _ := ioutil.ReadFile(file)
+	for _, n := range []int{0, len(data) / 2, len(data) - 1} {
+		ioutil.WriteFile(file, data[:n], 0644)
+
+		res, err := LoadMedia(rep)
+		if err != nil {
+			t.Fatalf(TMPL_ERROR, err, rep.Name)
+		}
+
+		if !reflect.DeepEqual(res, pkgs) {
+			t.Errorf(TMPL_MISMATCH, n, pkgs, res)
+		}
+	}
+}
+
+func TestLoadMediaWithoutMD5SUM(t *testing.T) {
+	dir, err := createDirs()
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	IndexCacheDir = dir + "/cache"
+	rep := Repository{Name: "Test", Dir: dir + "/var/Test"}
+
+	// The media can't be verified, but it's loaded like before
+	createPkgFiles(t, dir, "Test", []Package{saxpath_103})
+	os.Remove(rep.Dir + "/MD5SUM")
+
+	pkgs, err := LoadMedia(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if len(pkgs) != 1 || pkgs[0].FileName != saxpath_103.FileName {
+		t.Errorf(TMPL_MISMATCH, "packages", saxpath_103.FileName, pkgs)
+	}
+
+	if _, err := LoadMediaInfo(rep); err != nil {
+		t.Errorf(TMPL_ERROR, err, rep.Name)
+	}
+
+	// A corrupted file of the verified media is an error
+	ioutil.WriteFile(rep.Dir+"/MD5SUM", []byte("00000000000000000000000000000000  synthesis.hdlist.cz\n"), 0644)
+	_, err = LoadMedia(rep)
+	if err == nil || strings.Count(err.Error(), "zrpm update") != 1 {
+		t.Errorf(TMPL_MISMATCH, "broken media", "Media Test is broken ... Run zrpm update to fix it.", err)
+	}
+}
+
+func TestLoadMediaWithoutInfo(t *testing.T) {
+	dir, err := createDirs()
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	IndexCacheDir = dir + "/cache"
+	rep := Repository{Name: "Test", Dir: dir + "/var/Test"}
+
+	// A media with "xml-info: never" has only the synthesis file
+	createPkgFiles(t, dir, "Test", []Package{saxpath_103})
+	ioutil.WriteFile(rep.Dir+"/MD5SUM", []byte(fileMd5(rep.Dir+"/synthesis.hdlist.cz")+"  synthesis.hdlist.cz\n"), 0644)
+
+	// The info file which isn't listed in MD5SUM is read unverified
+	info, err := LoadMediaInfo(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if _, ok := info[saxpath_103.FileName]; !ok {
+		t.Errorf(TMPL_MISMATCH, "unlisted info", saxpath_103.FileName, info)
+	}
+
+	removeMediaIndex(rep.Name)
+	os.Remove(rep.Dir + "/info.xml.lzma")
+
+	info, err = LoadMediaInfo(rep)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if len(info) != 0 {
+		t.Errorf(TMPL_MISMATCH, "missing info", 0, len(info))
+	}
+}
+
+// createSyntheticMedia creates a media with n packages similar to the
+// packages of a full distribution mirror.
+func createSyntheticMedia(b *testing.B, n int) (Repository, func()) {
+	dir, err := createDirs()
+	if err != nil {
+		b.Fatal("Can't ceate tmp dir:", err)
+	}
+
+	desc := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 5)
+
+	pkgs := make([]Package, n)
+	for i := range pkgs {
+		name := fmt.Sprintf("package%05d", i)
+		pkgs[i] = Package{
+			FileName:    name + "-1.0-1-rosa2014.1.x86_64",
+			Disttag:     "rosa",
+			Sourcerpm:   name + "-1.0-1.src.rpm",
+			URL:         "http://example.com/" + name,
+			License:     "GPLv2+",
+			Description: desc,
+			Summary:     "Synthetic package " + name,
+			Size:        12345,
+			Group:       "System/Libraries",
+			Provides: []Dependency{
+				{Name: name, Flags: "==", EVR: "1.0-1"},
+				{Name: "lib" + name + ".so.1()(64bit)"},
+			},
+			Requires: []Dependency{
+				{Name: "libc.so.6()(64bit)"},
+				{Name: fmt.Sprintf("package%05d", (i+1)%n), Flags: ">=", EVR: "1.0"},
+			},
+		}
+	}
+
+	IndexCacheDir = dir + "/cache"
+	createPkgFiles(b, dir, "Synthetic", pkgs)
+
+	return Repository{Name: "Synthetic", Dir: dir + "/var/Synthetic"}, func() { os.RemoveAll(dir) }
+}
+
+// BenchmarkParseMedia measures reading the packages from the synthesis
+// file of a media, like LoadMedia does without the index. The files are
+// not verified, like in BenchmarkLoadMediaIndex.
+func BenchmarkParseMedia(b *testing.B) {
+	rep, cleanup := createSyntheticMedia(b, 40000)
+	defer cleanup()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := parseSynthesis(rep); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLoadMediaIndex measures loading the packages of a media from
+// the up to date index, like zrpm search does.
+func BenchmarkLoadMediaIndex(b *testing.B) {
+	rep, cleanup := createSyntheticMedia(b, 40000)
+	defer cleanup()
+
+	if _, err := LoadMedia(rep); err != nil {
+		b.Fatal(err)
+	}
+
+	if files, _ := ioutil.ReadDir(IndexCacheDir); len(files) != 1 {
+		b.Fatal("Index was not created")
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := LoadMedia(rep); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLoadMediaInfo compares parsing info.xml.lzma of a media with
+// loading the records from the up to date index.
+func BenchmarkLoadMediaInfo(b *testing.B) {
+	rep, cleanup := createSyntheticMedia(b, 40000)
+	defer cleanup()
+
+	b.Run("parse", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := parseInfo(rep); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	if _, err := LoadMediaInfo(rep); err != nil {
+		b.Fatal(err)
+	}
+
+	if !fileExists(mediaIndexFile(rep.Name, infoLayer)) {
+		b.Fatal("Index was not created")
+	}
+
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := LoadMediaInfo(rep); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,241 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+)
+
+// The index data is a flat sequence of varints and length-prefixed
+// strings. The reader converts the whole file to one string, the decoded
+// strings and dependency lists share its memory, so loading a media from
+// the index takes a few allocations instead of several per package.
+
+// indexWriter encodes the index data into buf.
+type indexWriter struct {
+	buf []byte
+	tmp [binary.MaxVarintLen64]byte
+}
+
+func (w *indexWriter) int(v int) {
+	n := binary.PutVarint(w.tmp[:], int64(v))
+	w.buf = append(w.buf, w.tmp[:n]...)
+}
+
+func (w *indexWriter) str(s string) {
+	w.int(len(s))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *indexWriter) deps(deps []Dependency) {
+	w.int(len(deps))
+	for _, d := range deps {
+		w.str(d.Name)
+		w.str(d.Flags)
+		w.str(d.EVR)
+	}
+}
+
+// indexReader decodes the index data. A read past the end or an incorrect
+// value sets bad, all following reads return zero values.
+type indexReader struct {
+	data string
+	pos  int
+	bad  bool
+}
+
+func newIndexReader(data []byte) *indexReader {
+	return &indexReader{data: string(data)}
+}
+
+func (r *indexReader) int() int {
+	var ux uint64
+	for shift := uint(0); !r.bad; shift += 7 {
+		if r.pos >= len(r.data) || shift > 63 {
+			r.bad = true
+			break
+		}
+
+		b := r.data[r.pos]
+		r.pos++
+		ux |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			break
+		}
+	}
+
+	if r.bad {
+		return 0
+	}
+
+	// Zigzag decoding like binary.Varint
+	x := int64(ux >> 1)
+	if ux&1 != 0 {
+		x = ^x
+	}
+	return int(x)
+}
+
+// count reads the number of the following items, each of them takes at
+// least size bytes. Counts which can't fit into the rest of the data are
+// incorrect.
+func (r *indexReader) count(size int) int {
+	n := r.int()
+	if n < 0 || n > (len(r.data)-r.pos)/size {
+		r.bad = true
+		return 0
+	}
+	return n
+}
+
+func (r *indexReader) str() string {
+	n := r.count(1)
+	if r.bad {
+		return ""
+	}
+
+	s := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return s
+}
+
+// deps returns the next dependency list from the buffer shared by all
+// packages. The list capacity is limited, so an append can't overwrite
+// the dependencies of the next package.
+func (r *indexReader) deps(buf []Dependency) ([]Dependency, []Dependency) {
+	n := r.count(3)
+	if r.bad || n > len(buf) {
+		r.bad = true
+		return nil, buf
+	}
+
+	if n == 0 {
+		return nil, buf
+	}
+
+	for i := range buf[:n] {
+		buf[i] = Dependency{Name: r.str(), Flags: r.str(), EVR: r.str()}
+	}
+	return buf[:n:n], buf[n:]
+}
+
+// done reports whether all data was decoded without errors.
+func (r *indexReader) done() bool {
+	return !r.bad && r.pos == len(r.data)
+}
+
+// encodePackages writes the packages of the synthesis layer. The total
+// number of dependencies goes first, so the reader allocates them at once.
+func encodePackages(w *indexWriter, pkgs Packages) {
+	total := 0
+	for _, p := range pkgs {
+		total += len(p.Provides) + len(p.Requires) + len(p.Conflicts) + len(p.Obsoletes) + len(p.Suggests)
+	}
+
+	w.int(len(pkgs))
+	w.int(total)
+	for _, p := range pkgs {
+		w.str(p.FileName)
+		w.str(p.Name)
+		w.str(p.Disttag)
+		w.str(p.Distepoch)
+		w.str(p.Sourcerpm)
+		w.str(p.URL)
+		w.str(p.License)
+		w.str(p.Description)
+		w.str(p.Arch)
+		w.int(p.Epoch)
+		w.str(p.Version)
+		w.str(p.Summary)
+		w.int(p.Size)
+		w.int(p.RPMSize)
+		w.str(p.Group)
+		w.str(p.Repository)
+
+		w.deps(p.Provides)
+		w.deps(p.Requires)
+		w.deps(p.Conflicts)
+		w.deps(p.Obsoletes)
+		w.deps(p.Suggests)
+	}
+}
+
+// decodePackages reads the packages written by encodePackages.
+func decodePackages(r *indexReader) (Packages, bool) {
+	pkgs := make(Packages, r.count(1))
+	deps := make([]Dependency, r.count(3))
+
+	for i := range pkgs {
+		p := &pkgs[i]
+		p.FileName = r.str()
+		p.Name = r.str()
+		p.Disttag = r.str()
+		p.Distepoch = r.str()
+		p.Sourcerpm = r.str()
+		p.URL = r.str()
+		p.License = r.str()
+		p.Description = r.str()
+		p.Arch = r.str()
+		p.Epoch = r.int()
+		p.Version = r.str()
+		p.Summary = r.str()
+		p.Size = r.int()
+		p.RPMSize = r.int()
+		p.Group = r.str()
+		p.Repository = r.str()
+
+		p.Provides, deps = r.deps(deps)
+		p.Requires, deps = r.deps(deps)
+		p.Conflicts, deps = r.deps(deps)
+		p.Obsoletes, deps = r.deps(deps)
+		p.Suggests, deps = r.deps(deps)
+	}
+
+	if !r.done() {
+		return nil, false
+	}
+	return pkgs, true
+}
+
+// encodeInfo writes the records of the info layer.
+func encodeInfo(w *indexWriter, info map[string]InfoRecord) {
+	w.int(len(info))
+	for _, i := range info {
+		w.str(i.Filename)
+		w.str(i.Name)
+		w.str(i.Sourcerpm)
+		w.str(i.URL)
+		w.str(i.License)
+		w.str(i.Description)
+		w.str(i.Distepoch)
+		w.str(i.Disttag)
+	}
+}
+
+// decodeInfo reads the records written by encodeInfo.
+func decodeInfo(r *indexReader) (map[string]InfoRecord, bool) {
+	n := r.count(8)
+	info := make(map[string]InfoRecord, n)
+
+	for j := 0; j < n; j++ {
+		i := InfoRecord{
+			Filename:    r.str(),
+			Name:        r.str(),
+			Sourcerpm:   r.str(),
+			URL:         r.str(),
+			License:     r.str(),
+			Description: r.str(),
+			Distepoch:   r.str(),
+			Disttag:     r.str(),
+		}
+		info[i.Filename] = i
+	}
+
+	if !r.done() {
+		return nil, false
+	}
+	return info, true
+}
@@ -49,57 +49,68 @@ func ReadInfoFile(file string, out chan<- InfoRecord) error {
 		return err
 	}
 
+	return parseInfoData(buf, chankSize, out)
+}
+
+// parseInfoData parses the content of info.xml.lzma in parallel, chunkSize
+// bytes per goroutine.
+func parseInfoData(buf []byte, chunkSize int, out chan<- InfoRecord) error {
 	var wg sync.WaitGroup
 	sz := len(buf)
-	for i := 0; i < sz; i += chankSize {
-		to := i + chankSize
+	errs := make([]error, (sz+chunkSize-1)/chunkSize)
+	for i := 0; i < sz; i += chunkSize {
+		to := i + chunkSize
 		if to > sz {
 			to = sz
 		}
 
 		wg.Add(1)
-		go func(f int, t int) {
+		go func(n int, f int, t int) {
 			defer wg.Done()
-			err = extractInfo(buf, f, t, out)
-		}(i, to)
+			errs[n] = extractInfo(buf, f, t, out)
+		}(i/chunkSize, i, to)
 	}
 
 	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// extractInfo parses the records which start in buffer[begin:end]. The
+// chunk may start in the middle of a record, it belongs to the previous
+// chunk.
 func extractInfo(buffer []byte, begin int, end int, out chan<- InfoRecord) error {
-	buf := buffer[begin:]
 	n := begin
-	for true {
-		b := bytes.Index(buf, []byte("<info "))
-		e := bytes.Index(buf, []byte("</info>"))
-
+	for {
+		b := bytes.Index(buffer[n:], []byte("<info "))
 		if b < 0 {
 			return nil
 		}
 
+		b += n
+		if b >= end {
+			return nil
+		}
+
+		e := bytes.Index(buffer[b:], []byte("</info>"))
 		if e < 0 {
-			return fmt.Errorf("Can't parse %s", "file")
+			return fmt.Errorf("unterminated <info> at offset %d", b)
 		}
-		e += 7
+		e += b + 7
 
 		var res InfoRecord
 
-		err := xml.Unmarshal(buf[b:e], &res)
+		err := xml.Unmarshal(buffer[b:e], &res)
 		if err != nil {
-			return err
+			return fmt.Errorf("incorrect record at offset %d: %v", b, err)
 		}
 
 		out <- res
-
-		buf = buf[e:]
-		n += e
-
-		if n > end {
-			return nil
-		}
+		n = e
 	}
-
-	return nil
 }
@@ -0,0 +1,97 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/lzma"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeInfoFile(t *testing.T, file string, data string) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal("Can't ceate info.xml.lzma file:", err)
+	}
+	defer f.Close()
+
+	lz := lzma.NewWriter(f)
+	defer lz.Close()
+	lz.Write([]byte(data))
+}
+
+func collectInfo(read func(out chan<- InfoRecord) error) (map[string]InfoRecord, error) {
+	out := make(chan InfoRecord, 100)
+	var err error
+	go func() {
+		defer close(out)
+		err = read(out)
+	}()
+
+	res := map[string]InfoRecord{}
+	for i := range out {
+		res[i.Filename] = i
+	}
+	return res, err
+}
+
+func TestParseInfoData(t *testing.T) {
+	// The records cross the chunk borders
+	n := 100
+	var data bytes.Buffer
+	data.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n<media_info>")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&data, "<info fn='pkg%03d-1.0-1-rosa2014.1.x86_64' url='http://example.com/'>%s</info>",
+			i, strings.Repeat("x", i))
+	}
+	data.WriteString("</media_info>")
+
+	for _, chunk := range []int{7, 64, 1000, data.Len()} {
+		res, err := collectInfo(func(out chan<- InfoRecord) error {
+			return parseInfoData(data.Bytes(), chunk, out)
+		})
+
+		if err != nil {
+			t.Fatalf(TMPL_ERROR, err, chunk)
+		}
+
+		if len(res) != n {
+			t.Errorf(TMPL_MISMATCH, chunk, n, len(res))
+		}
+
+		if r := res["pkg042-1.0-1-rosa2014.1.x86_64"]; r.Description != strings.Repeat("x", 42) {
+			t.Errorf(TMPL_MISMATCH, chunk, strings.Repeat("x", 42), r.Description)
+		}
+	}
+}
+
+func TestReadBrokenInfoFile(t *testing.T) {
+	dir, err := createDirs()
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := dir + "/info.xml.lzma"
+	cases := []string{
+		"<media_info><info fn='a'>text</info><info fn='b'>text</media_info>",
+		"<media_info><info fn='a>text</info></media_info>",
+	}
+
+	for _, c := range cases {
+		writeInfoFile(t, file, c)
+		_, err := collectInfo(func(out chan<- InfoRecord) error {
+			return ReadInfoFile(file, out)
+		})
+
+		if err == nil {
+			t.Errorf("Broken info file must be rejected: %s", c)
+		}
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -21,6 +23,9 @@ const (
 	author  = "Alexander Sokolov <sokoloff.a@gmail.com>"
 )
 
+// Set by the --skip-broken-media option
+var skipBrokenMedia bool
+
 var (
 	colorNorm   = "\x1b[0m"
 	colorBold   = "\x1b[1m"
@@ -110,19 +115,109 @@ func checkArgs(c *cli.Context) {
 	}
 }
 
+// loadCache loads the package cache. With --skip-broken-media the media
+// which can't be loaded are skipped with a warning.
+func loadCache(opts CacheOptions) *Cache {
+	opts.SkipBrokenMedia = skipBrokenMedia
+	cache, err := NewCache(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, w := range cache.Warnings() {
+		colorPrintf("{YELLOW}Warning:{NORM} %v\nThe media is skipped.\n", w)
+	}
+	return cache
+}
+
+// expandQuery adds the lib and lib64 spellings of the library names, the
+// native variant for the machine goes first.
 func expandQuery(query []string) []string {
 	res := []string{}
 	for _, s := range query {
-		res = append(res, s)
-		if strings.HasPrefix(s, "lib") && !strings.HasPrefix(s, "lib64") {
-			res = append(res, "lib64"+s[3:])
+		res = append(res, libNameVariants(s, machineArch())...)
+	}
+	return res
+}
+
+// nativeName returns the name if exists returns true for it. Otherwise it
+// tries the other lib/lib64 spellings, the native variant first, and
+// returns the name as is if none of them exist.
+func nativeName(name string, exists func(string) bool) string {
+	if exists(name) {
+		return name
+	}
+
+	for _, n := range expandQuery([]string{name}) {
+		if exists(n) {
+			return n
+		}
+	}
+	return name
+}
+
+// queryTerms returns the command line terms with their alternative
+// spellings.
+func queryTerms(args []string) [][]string {
+	res := [][]string{}
+	for _, a := range args {
+		res = append(res, expandQuery([]string{a}))
+	}
+	return res
+}
+
+// Flags of the commands which look for packages by name
+var matchModeFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "exact",
+		Usage: "Match the whole name exactly.",
+	},
+
+	cli.BoolFlag{
+		Name:  "glob",
+		Usage: "Match the whole name with a shell pattern like 'python3-*'.",
+	},
+
+	cli.BoolFlag{
+		Name:  "regex",
+		Usage: "Match the name with a regular expression like '^lib64qt5(core|gui)'.",
+	},
+}
+
+func getMatchMode(c *cli.Context) MatchMode {
+	res := MatchSubstring
+	n := 0
+	for _, m := range []struct {
+		flag string
+		mode MatchMode
+	}{
+		{"exact", MatchExact},
+		{"glob", MatchGlob},
+		{"regex", MatchRegex},
+	} {
+		if c.Bool(m.flag) {
+			res = m.mode
+			n++
 		}
 	}
+
+	if n > 1 {
+		log.Fatal("Only one of --exact, --glob and --regex can be used")
+	}
 	return res
 }
 
 func mainRepo(c *cli.Context) {
+	// The cli package checks --help only in the parent context for the
+	// commands with subcommands
+	if c.Bool("help") {
+		cli.ShowSubcommandHelp(c)
+		return
+	}
+
 	showAll := c.Bool("all")
+	verify := c.Bool("verify")
+	failed := false
 
 	reps, err := GetRepositories()
 	if err != nil {
@@ -143,9 +238,190 @@ func mainRepo(c *cli.Context) {
 			fmt.Printf("%v\n", rep.LastUpdate.Format("15:04 01 Jan 2006"))
 		}
 
-		fmt.Printf("    URL: %v\n", rep.URL)
+		if rep.Mirrorlist != "" && rep.URL == "" {
+			fmt.Printf("    mirrorlist: %v\n", rep.Mirrorlist)
+			if urls, err := rep.BaseURLs(); err != nil {
+				colorPrintf("    mirror: {YELLOW}unknown{NORM} (%v)\n", err)
+			} else {
+				fmt.Printf("    mirror: %v\n", urls[0])
+			}
+		} else {
+			fmt.Printf("    URL: %v\n", rep.URL)
+		}
+
+		if verify && !rep.Ignore {
+			if errs := rep.Verify(); len(errs) > 0 {
+				colorPrintf("    status: {YELLOW}broken{NORM}\n")
+				for _, err := range errs {
+					fmt.Printf("        %v\n", err)
+				}
+				failed = true
+			} else {
+				colorPrintf("    status: {GREEN}OK{NORM}\n")
+			}
+		}
 		fmt.Println("")
 	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func checkMediaArgs(c *cli.Context, count int) {
+	if (count > 0 && len(c.Args()) != count) || len(c.Args()) == 0 {
+		fmt.Println("Incorrect number of arguments")
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+}
+
+// editRepositories reads urpmi.cfg, applies fn and saves the result.
+func editRepositories(fn func(cfg *UrpmiCfg) error) {
+	cfg, err := ReadUrpmiCfgForUpdate()
+	if err != nil {
+		log.Fatal("Can't read urpi.cfg file: ", err)
+	}
+
+	if err := fn(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := cfg.Save(UrpmiCfgFile()); err != nil {
+		log.Fatal("Can't write urpi.cfg file: ", err)
+	}
+}
+
+func mainRepoAdd(c *cli.Context) {
+	added := []string{}
+
+	if distrib := c.String("distrib"); distrib != "" {
+		if len(c.Args()) != 0 {
+			fmt.Println("The media are taken from the distribution, don't provide a media name")
+			cli.ShowSubcommandHelp(c)
+			os.Exit(2)
+		}
+
+		media, err := ReadDistribMedia(distrib)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		editRepositories(func(cfg *UrpmiCfg) error {
+			for _, m := range media {
+				if m.NoAuto && !c.Bool("all-media") {
+					continue
+				}
+
+				if err := AddRepository(cfg, m.Name, m.URL, m.Update); err != nil {
+					return err
+				}
+				added = append(added, m.Name)
+			}
+			return nil
+		})
+	} else {
+		checkMediaArgs(c, 2)
+		editRepositories(func(cfg *UrpmiCfg) error {
+			added = append(added, c.Args()[0])
+			return AddRepository(cfg, c.Args()[0], c.Args()[1], c.Bool("update"))
+		})
+	}
+
+	for _, name := range added {
+		dir, err := MediaDir(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Media %s added\n", name)
+	}
+
+	if c.Bool("fetch") && !updateRepositories(added) {
+		os.Exit(1)
+	}
+}
+
+func mainRepoRemove(c *cli.Context) {
+	checkMediaArgs(c, 0)
+
+	dirs := []string{}
+	for _, name := range c.Args() {
+		dir, err := MediaDir(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	editRepositories(func(cfg *UrpmiCfg) error {
+		for _, name := range c.Args() {
+			if err := RemoveRepository(cfg, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for i, name := range c.Args() {
+		if err := os.RemoveAll(dirs[i]); err != nil {
+			log.Fatal(err)
+		}
+		removeMediaIndex(name)
+		fmt.Printf("Media %s removed\n", name)
+	}
+}
+
+func mainRepoEnable(c *cli.Context) {
+	checkMediaArgs(c, 0)
+	editRepositories(func(cfg *UrpmiCfg) error {
+		for _, name := range c.Args() {
+			if err := EnableRepository(cfg, name, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if c.Bool("fetch") && !updateRepositories(c.Args()) {
+		os.Exit(1)
+	}
+}
+
+func mainRepoDisable(c *cli.Context) {
+	checkMediaArgs(c, 0)
+	editRepositories(func(cfg *UrpmiCfg) error {
+		for _, name := range c.Args() {
+			if err := EnableRepository(cfg, name, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func mainRepoRename(c *cli.Context) {
+	checkMediaArgs(c, 2)
+	name, newName := c.Args()[0], c.Args()[1]
+
+	if _, err := MediaDir(name); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := MediaDir(newName); err != nil {
+		log.Fatal(err)
+	}
+
+	editRepositories(func(cfg *UrpmiCfg) error {
+		return RenameRepository(cfg, name, newName)
+	})
+
+	if err := RenameMediaDir(name, newName); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func colorizeResultStringOne(q, str string) string {
@@ -186,32 +462,152 @@ func mainSearch(c *cli.Context) {
 		return
 	}
 
+	fields := SearchFields{
+		Summary:     c.Bool("summary"),
+		Description: c.Bool("description"),
+	}
+
+	if c.Bool("all-fields") {
+		fields = SearchFields{Summary: true, Description: true, URL: true, Group: true}
+	}
+
+	q := SearchQuery{
+		Terms:    queryTerms(query),
+		Mode:     getMatchMode(c),
+		Fields:   fields,
+		MatchAll: c.Bool("all-terms"),
+	}
+
+	order := c.String("sort")
+	if err := checkSortOrder(order); err != nil {
+		log.Fatal(err)
+	}
+
 	// Search .........................
-	cache := NewCache()
-	query = expandQuery(query)
-	out := cache.SearchByName(query, getArch(c), !c.Bool("showduplicates"))
+	cache := loadCache(CacheOptions{Info: fields.NeedInfo()})
+	out, err := cache.Search(q, getArch(c), !c.Bool("showduplicates"))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Out ............................
+	query = expandQuery(query)
+	if order == SortName {
+		for pkg := range out {
+			printPackageLine(query, pkg)
+		}
+		return
+	}
+
+	pkgs := Packages{}
 	for pkg := range out {
+		pkgs = append(pkgs, pkg)
+	}
+
+	if err := SortSearchResults(pkgs, q, order); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, pkg := range pkgs {
+		printPackageLine(query, pkg)
+	}
+}
+
+func printPackageLine(query []string, pkg Package) {
+	for _, line := range packageLines(query, pkg) {
+		fmt.Println(line)
+	}
+}
+
+// packageLines returns the result line of the package. If other versions
+// or architectures of the package are installed, all installed instances
+// follow on separate lines.
+func packageLines(query []string, pkg Package) []string {
+	color := ""
+	state := " "
+	switch pkg.State() {
+	case PACKAGE_INSATALLED:
+		color = colorGreen
+		state = "I"
+
+	case PACKAGE_UPDATE:
+		color = colorYellow
+		state = "U"
+	}
+
+	line := ""
+	line += color + state + colorNorm
+	line += colorizeResultString(query, fmt.Sprintf("  %-40s ", pkg.Name))
+	line += color + fmt.Sprintf("%-20s", pkg.FullVersion()) + colorNorm
+	line += fmt.Sprintf("%-8s ", pkg.Arch)
+	line += colorizeResultString(query, pkg.Summary)
+	res := []string{line}
+
+	if len(pkg.Installed) == 0 ||
+		len(pkg.Installed) == 1 && pkg.Installed[0].Arch == pkg.Arch && pkg.Installed[0].FullVersion() == pkg.FullVersion() {
+		return res
+	}
+
+	matched := map[NEVRA]bool{}
+	for _, n := range pkg.InstalledInstances() {
+		matched[n] = true
+	}
+
+	for _, n := range pkg.Installed {
 		color := ""
-		state := " "
-		switch pkg.State() {
-		case PACKAGE_INSATALLED:
+		if matched[n] {
 			color = colorGreen
-			state = "I"
+			if n.EVR().Compare(pkg.EVR()) < 0 {
+				color = colorYellow
+			}
+		}
 
-		case PACKAGE_UPDATE:
-			color = colorYellow
-			state = "U"
+		res = append(res, fmt.Sprintf("   %40s %s%-20s%s%-8s", "installed:", color, n.FullVersion(), colorNorm, n.Arch))
+	}
+	return res
+}
+
+// parseDependencyArgs joins command line arguments into dependencies, so
+// both "libfoo >= 1.2" and libfoo '>=' 1.2 are accepted.
+func parseDependencyArgs(args []string) ([]Dependency, error) {
+	res := []Dependency{}
+	for i := 0; i < len(args); i++ {
+		s := args[i]
+		if i+1 < len(args) && args[i+1] != "" && strings.Trim(args[i+1], "<>=") == "" {
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("version expected after '%s %s'", s, args[i+1])
+			}
+			s += " " + args[i+1] + " " + args[i+2]
+			i += 2
 		}
 
-		line := ""
-		line += color + state + colorNorm
-		line += colorizeResultString(query, fmt.Sprintf("  %-40s ", pkg.Name))
-		line += color + fmt.Sprintf("%-20s", pkg.Version) + colorNorm
-		line += fmt.Sprintf("%-8s ", pkg.Arch)
-		line += colorizeResultString(query, pkg.Summary)
-		fmt.Println(line)
+		dep, err := ParseDependency(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, dep)
+	}
+	return res, nil
+}
+
+func mainWhatProvides(c *cli.Context) {
+	checkArgs(c)
+
+	deps, err := parseDependencyArgs(c.Args())
+	if err != nil {
+		log.Fatal("Incorrect query: ", err)
+	}
+
+	cache := loadCache(CacheOptions{})
+	for _, dep := range deps {
+		out, err := cache.WhatProvides(dep, getArch(c), !c.Bool("showduplicates"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for pkg := range out {
+			printPackageLine(nil, pkg)
+		}
 	}
 }
 
@@ -225,114 +621,798 @@ func mainShow(c *cli.Context) {
 	}
 
 	// Search .........................
-	cache := NewCache()
-	query = expandQuery(query)
-	out := cache.SearchByName(query, getArch(c), !c.Bool("showduplicates"))
+	cache := loadCache(CacheOptions{})
+	q := SearchQuery{Terms: queryTerms(query), Mode: getMatchMode(c)}
+	out, err := cache.Search(q, getArch(c), !c.Bool("showduplicates"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every term gets its own "not found" message
+	terms := make([][]searchTerm, len(q.Terms))
+	for i, alts := range q.Terms {
+		terms[i], _ = compileSearchTerms([][]string{alts}, q.Mode)
+	}
+	found := make([]bool, len(terms))
 
 	// Out ............................
 	for pkg := range out {
-		colorPrintf("Name        : {BOLD}%s{NORM}\n", pkg.Name)
-		colorPrintf("Summary     : %s\n", pkg.Summary)
-		colorPrintf("Version     : %-10s %-10s\n", pkg.Version, pkg.Arch)
-		switch pkg.State() {
-		case PACKAGE_NOTINSATALLED:
-			colorPrintf("Not installed\n")
-
-		case PACKAGE_INSATALLED:
-			colorPrintf("Installed   : {GREEN}%s{NORM}\n", pkg.InstalledVer)
-
-		case PACKAGE_UPDATE:
-			colorPrintf("Installed   : {YELLOW}%s{NORM}\n", pkg.InstalledVer)
-		}
-
-		colorPrintf("Group       : %s\n", pkg.Group)
-		colorPrintf("Size        : RPM: %v     Files: %v\n", pkg.RPMSize, pkg.Size)
-		colorPrintf("Source RPM  : %s\n", pkg.Sourcerpm)
-		colorPrintf("URL         : %s\n", pkg.URL)
-		colorPrintf("Repository  : %s\n", pkg.Repository)
-		s := strings.TrimLeft(pkg.Description, "\n")
-		colorPrintf(s)
+		for i, t := range terms {
+			found[i] = found[i] || matchSearchTerms(t, pkg, q.Fields, false, getArch(c))
+		}
+
+		pkg, err := cache.WithInfo(pkg)
+		if err != nil {
+			if !skipBrokenMedia {
+				log.Fatal(err)
+			}
+			colorPrintf("{YELLOW}Warning:{NORM} %v\n", err)
+		}
+
+		printPackageInfo(pkg)
 		fmt.Println("")
 	}
-}
 
-func prepend(arr []string, a ...string) []string {
-	return append(a, arr...)
+	if q.Mode == MatchSubstring || q.Mode == MatchExact {
+		for i, name := range query {
+			if !found[i] && name != "" {
+				printNotFound(cache, name)
+			}
+		}
+	}
 }
 
-func mainInstall(c *cli.Context) {
-	checkArgs(c)
-	execute(prepend(c.Args(), "sudo", "urpmi")...)
+func printPackageInfo(pkg Package) {
+	colorPrintf("Name        : {BOLD}%s{NORM}\n", pkg.Name)
+	colorPrintf("Summary     : %s\n", pkg.Summary)
+	colorPrintf("Version     : %-10s %-10s\n", pkg.FullVersion(), pkg.Arch)
+	printInstalled(pkg)
+
+	colorPrintf("Group       : %s\n", pkg.Group)
+	colorPrintf("Size        : RPM: %v     Files: %v\n", pkg.RPMSize, pkg.Size)
+	colorPrintf("Source RPM  : %s\n", pkg.Sourcerpm)
+	colorPrintf("URL         : %s\n", pkg.URL)
+	colorPrintf("Repository  : %s\n", pkg.Repository)
+	s := strings.TrimLeft(pkg.Description, "\n")
+	colorPrintf(s)
 }
 
-func mainRemove(c *cli.Context) {
-	execute(prepend(c.Args(), "sudo", "urpme")...)
-}
+func printDependencies(title string, deps []Dependency) {
+	if len(deps) == 0 {
+		return
+	}
 
-func mainUpdate(c *cli.Context) {
-	execute("sudo", "urpmi.update", "-a")
+	colorPrintf("{BOLD}%s:{NORM}\n", title)
+	for _, d := range deps {
+		fmt.Printf("    %s\n", d)
+	}
 }
 
-func mainUpgrade(c *cli.Context) {
-	execute("sudo", "urpmi", "--auto-select")
-}
+func mainQuery(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Println("You must provide at least one RPM file")
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
 
-func mainDownload(c *cli.Context) {
-	checkArgs(c)
-	execute(prepend(c.Args(), "urpm-downloader", "--binary")...)
-}
+	installed := map[string][]NEVRA{}
+	if pkgs, err := fillInstalledInfo(); err == nil {
+		for _, p := range pkgs {
+			installed[p.Name] = append(installed[p.Name], NEVRA{Name: p.Name, Epoch: p.Epoch, Version: p.Version, Arch: p.Arch})
+		}
+	}
 
-func mainSource(c *cli.Context) {
-	checkArgs(c)
-	execute(prepend(c.Args(), "urpm-downloader", "--source")...)
+	for _, file := range c.Args() {
+		rpm, err := ReadRPMFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		pkg := rpm.Package(file)
+		pkg.Repository = file
+		pkg.Installed = installed[pkg.Name]
+
+		printPackageInfo(pkg)
+		fmt.Println("")
+
+		printDependencies("Provides", pkg.Provides)
+		printDependencies("Requires", pkg.Requires)
+		printDependencies("Conflicts", pkg.Conflicts)
+		printDependencies("Obsoletes", pkg.Obsoletes)
+		printDependencies("Suggests", pkg.Suggests)
+
+		if files := rpm.Header.Files(); len(files) > 0 {
+			colorPrintf("{BOLD}Files:{NORM}\n")
+			for _, f := range files {
+				fmt.Printf("    %s\n", f)
+			}
+		}
+		fmt.Println("")
+	}
 }
 
-func mainFiles(c *cli.Context) {
-	checkArgs(c)
-	execute(prepend(c.Args(), "urpmf", "-f")...)
+func mainVerifySig(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Println("You must provide at least one RPM file")
+		cli.ShowSubcommandHelp(c)
+		os.Exit(2)
+	}
+
+	keyring, err := LoadKeyring(GpgKeyDir, RpmDbDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for _, file := range c.Args() {
+		signer, err := VerifyRPMSignature(file, keyring)
+		if err != nil {
+			colorPrintf("{YELLOW}NOT OK{NORM} %v\n", err)
+			failed = true
+			continue
+		}
+
+		colorPrintf("{GREEN}OK{NORM}     %s: signed by %s\n", file, SignerName(signer))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
 }
 
-func mainFile(c *cli.Context) {
+func mainWhatRequires(c *cli.Context) {
 	checkArgs(c)
-	execute(prepend(c.Args(), "urpmf", "-f")...)
-}
 
-func main() {
+	cache := loadCache(CacheOptions{})
+	arch := getArch(c)
+	onlyLast := !c.Bool("showduplicates")
+	installedOnly := c.Bool("installed-only")
+	idx := cache.NewRequiresIndex()
 
-	app := cli.NewApp()
-	app.Name = "zrpm"
-	app.Usage = "zrpm is a text-based interface to the RPM package system."
-	app.Version = version
-	app.Author = author
+	for _, name := range c.Args() {
+		targets := Packages{}
+		for target := range cache.SearchExact([]string{name}, arch, true) {
+			targets = append(targets, target)
+		}
 
-	app.Flags = []cli.Flag{
-		cli.BoolFlag{
-			Name:  "nocolor",
-			Usage: "Force black and white output",
-		},
+		// The package may be installed but missing on the media
+		if len(targets) == 0 {
+			for _, pkg := range idx.Installed {
+				if pkg.Name == name && compareArch(arch, pkg) {
+					targets = append(targets, pkg)
+				}
+			}
+		}
+
+		found := false
+		for _, target := range targets {
+			found = true
+
+			if !c.Bool("recursive") {
+				for pkg := range cache.WhatRequires(idx, target, arch, onlyLast, installedOnly) {
+					printPackageLine(nil, pkg)
+				}
+				continue
+			}
+
+			colorPrintf("{BOLD}%s-%s.%s{NORM}\n", target.Name, target.Version, target.Arch)
+			visited := map[string]bool{target.Name + "." + target.Arch: true}
+			printRequiresTree(cache, idx, target, arch, onlyLast, installedOnly, "", visited)
+		}
+
+		if !found {
+			fmt.Printf("Package %s not found\n", name)
+		}
 	}
+}
 
-	app.Commands = []cli.Command{
-		// Repos ......................
-		{
-			Name:  "repo",
-			Usage: "Display information about a repositories.",
-			Flags: []cli.Flag{
-				cli.BoolFlag{
-					Name:  "all",
-					Usage: "Show disabled repositories too.",
-				},
-			},
-			Action: mainRepo,
-		},
+func printRequiresTree(cache *Cache, idx *RequiresIndex, target Package, arch []string, onlyLast bool, installedOnly bool, indent string, visited map[string]bool) {
+	pkgs := []Package{}
+	for pkg := range cache.WhatRequires(idx, target, arch, onlyLast, installedOnly) {
+		pkgs = append(pkgs, pkg)
+	}
 
-		// Search .....................
-		{
-			Name:      "search",
+	for i, pkg := range pkgs {
+		branch, next := "├── ", "│   "
+		if i == len(pkgs)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		key := pkg.Name + "." + pkg.Arch
+		if visited[key] {
+			fmt.Printf("%s%s%s-%s.%s (*)\n", indent, branch, pkg.Name, pkg.FullVersion(), pkg.Arch)
+			continue
+		}
+
+		visited[key] = true
+		fmt.Printf("%s%s%s-%s.%s\n", indent, branch, pkg.Name, pkg.FullVersion(), pkg.Arch)
+		printRequiresTree(cache, idx, pkg, arch, onlyLast, installedOnly, indent+next, visited)
+	}
+}
+
+// printInstalled shows all installed instances of the package. Instances
+// with the package arch are colored by their update state.
+func printInstalled(pkg Package) {
+	if len(pkg.Installed) == 0 {
+		colorPrintf("Not installed\n")
+		return
+	}
+
+	matched := map[NEVRA]bool{}
+	for _, n := range pkg.InstalledInstances() {
+		matched[n] = true
+	}
+
+	title := "Installed   : "
+	for _, n := range pkg.Installed {
+		color := ""
+		if matched[n] {
+			color = colorGreen
+			if n.EVR().Compare(pkg.EVR()) < 0 {
+				color = colorYellow
+			}
+		}
+
+		colorPrintf("%s%s%-10s{NORM} %-10s\n", title, color, n.FullVersion(), n.Arch)
+		title = "              "
+	}
+}
+
+func prepend(arr []string, a ...string) []string {
+	return append(a, arr...)
+}
+
+func mainInstall(c *cli.Context) {
+	checkArgs(c)
+
+	cache := loadCache(CacheOptions{})
+	names := checkInstallNames(cache, c.Args(), getArch(c))
+
+	if c.Bool("plan") {
+		r := NewResolver(cache, getArch(c))
+		for _, name := range names {
+			// urpmi options don't change the plan
+			if strings.HasPrefix(name, "-") {
+				continue
+			}
+
+			candidates := installCandidates(cache, name, getArch(c))
+			if len(candidates) == 0 {
+				log.Fatalf("no package named %s", name)
+			}
+			r.InstallOneOf(Dependency{Name: name}, candidates)
+		}
+		printTransaction(r.Resolve())
+		return
+	}
+
+	execute(prepend(names, "sudo", "urpmi")...)
+	enforceCacheSize()
+}
+
+// installCandidates returns the packages the install argument may mean:
+// the packages found by the name, "name.arch" or "name-version", or if
+// there are none, the packages providing the capability or file.
+func installCandidates(cache *Cache, name string, arch []string) Packages {
+	res := Packages{}
+	out, err := cache.Search(SearchQuery{Terms: [][]string{{name}}, Mode: MatchExact}, arch, true)
+	if err == nil {
+		for pkg := range out {
+			res = append(res, pkg)
+		}
+	}
+
+	if len(res) > 0 {
+		return res
+	}
+
+	provides, err := cache.WhatProvides(Dependency{Name: name}, arch, true)
+	if err == nil {
+		for pkg := range provides {
+			res = append(res, pkg)
+		}
+	}
+	return res
+}
+
+// packageExists reports whether the name is a package, "name.arch",
+// "name-version" or a capability provided by a package.
+func packageExists(cache *Cache, name string, arch []string) bool {
+	return len(installCandidates(cache, name, arch)) > 0
+}
+
+// printNotFound tells that nothing was found for the name and prints the
+// closest package names.
+func printNotFound(cache *Cache, name string) {
+	colorPrintf("No package {BOLD}%s{NORM} found.", name)
+	if s := cache.Suggest(name, 5); len(s) > 0 {
+		fmt.Printf(" Did you mean %s?", strings.Join(s, ", "))
+	}
+	fmt.Println("")
+}
+
+// checkInstallNames offers the closest package names for the arguments
+// which are neither packages nor capabilities. Without a terminal the
+// suggestions are only printed and the names are left as is.
+func checkInstallNames(cache *Cache, args []string, arch []string) []string {
+	interactive := terminal.IsTerminal(int(os.Stdin.Fd()))
+
+	exists := func(name string) bool {
+		return packageExists(cache, name, arch)
+	}
+
+	res := []string{}
+	for _, name := range args {
+		// Options and files go to urpmi as is
+		if strings.HasPrefix(name, "-") || strings.Contains(name, "/") {
+			res = append(res, name)
+			continue
+		}
+
+		// libfoo-devel is lib64foo-devel on x86_64
+		if n := nativeName(name, exists); exists(n) {
+			res = append(res, n)
+			continue
+		}
+
+		suggestions := cache.Suggest(name, 5)
+		if len(suggestions) == 0 || !interactive {
+			printNotFound(cache, name)
+			res = append(res, name)
+			continue
+		}
+
+		colorPrintf("No package {BOLD}%s{NORM} found. Did you mean:\n", name)
+		n := askChoice(suggestions)
+		if n < 0 {
+			os.Exit(1)
+		}
+		res = append(res, suggestions[n])
+	}
+	return res
+}
+
+// askChoice prints the numbered choices and returns the index of the
+// selected one or -1 if the user cancelled.
+func askChoice(choices []string) int {
+	for i, s := range choices {
+		fmt.Printf("  %d) %s\n", i+1, s)
+	}
+
+	for {
+		fmt.Printf("Choose [1-%d], press Enter to cancel: ", len(choices))
+
+		answer := ""
+		fmt.Scanln(&answer)
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return -1
+		}
+
+		n, err := strconv.Atoi(answer)
+		if err == nil && n >= 1 && n <= len(choices) {
+			return n - 1
+		}
+	}
+}
+
+func mainRemove(c *cli.Context) {
+	installed := map[string]bool{}
+	if pkgs, err := fillInstalledInfo(); err == nil {
+		for _, p := range pkgs {
+			installed[p.Name] = true
+		}
+	}
+
+	names := []string{}
+	for _, name := range c.Args() {
+		names = append(names, nativeName(name, func(n string) bool { return installed[n] }))
+	}
+
+	execute(prepend(names, "sudo", "urpme")...)
+}
+
+func mainUpdate(c *cli.Context) {
+	if !updateRepositories(nil) {
+		os.Exit(1)
+	}
+}
+
+// updateRepositories fetches the metadata of the named media or of all
+// enabled media if names is nil. It returns false if any media failed.
+func updateRepositories(names []string) bool {
+	reps, err := GetRepositories()
+	if err != nil {
+		log.Fatal("Can't read urpi.cfg file: ", err)
+	}
+
+	want := map[string]bool{}
+	for _, n := range names {
+		want[n] = true
+	}
+
+	res := true
+	for _, rep := range reps {
+		if rep.Ignore || (names != nil && !want[rep.Name]) {
+			continue
+		}
+
+		colorPrintf("{BOLD}%s{NORM}: ", rep.Name)
+		updated, err := UpdateRepository(rep)
+		switch {
+		case err != nil:
+			colorPrintf("{YELLOW}failed{NORM}\n    %v\n", err)
+			res = false
+
+		case len(updated) == 0:
+			fmt.Println("up to date")
+
+		default:
+			fmt.Printf("updated %s\n", strings.Join(updated, ", "))
+		}
+	}
+
+	return res
+}
+
+func mainUpgrade(c *cli.Context) {
+	if c.Bool("plan") {
+		r := NewResolver(loadCache(CacheOptions{}), getArch(c))
+		r.UpgradeAll()
+		printTransaction(r.Resolve())
+		return
+	}
+
+	execute("sudo", "urpmi", "--auto-select")
+	enforceCacheSize()
+}
+
+func printTransaction(t Transaction) {
+	if len(t.Install)+len(t.Upgrade)+len(t.Erase)+len(t.Problems) == 0 {
+		fmt.Println("Nothing to do.")
+		return
+	}
+
+	if len(t.Install) > 0 {
+		colorPrintf("{BOLD}Install:{NORM}\n")
+		for _, pkg := range t.Install {
+			colorPrintf("    {GREEN}%-40s{NORM} %-20s %-8s %s\n", pkg.Name, pkg.FullVersion(), pkg.Arch, pkg.Repository)
+		}
+		fmt.Println("")
+	}
+
+	if len(t.Upgrade) > 0 {
+		colorPrintf("{BOLD}Upgrade:{NORM}\n")
+		for _, pkg := range t.Upgrade {
+			colorPrintf("    {YELLOW}%-40s{NORM} %-20s %-8s %s\n", pkg.Name, t.Replaces[pkgKey(pkg)].FullVersion()+" -> "+pkg.FullVersion(), pkg.Arch, pkg.Repository)
+		}
+		fmt.Println("")
+	}
+
+	if len(t.Erase) > 0 {
+		colorPrintf("{BOLD}Remove:{NORM}\n")
+		for _, pkg := range t.Erase {
+			colorPrintf("    %-40s %-20s %-8s %s\n", pkg.Name, pkg.FullVersion(), pkg.Arch, t.Reasons[instanceKey(pkg)])
+		}
+		fmt.Println("")
+	}
+
+	if len(t.Problems) > 0 {
+		colorPrintf("{BOLD}Problems:{NORM}\n")
+		for _, s := range t.Problems {
+			fmt.Printf("    %s\n", s)
+		}
+		fmt.Println("")
+	}
+
+	size := 0
+	for _, pkg := range append(t.Install, t.Upgrade...) {
+		size += pkg.RPMSize
+	}
+	fmt.Printf("%d to install, %d to upgrade, %d to remove, %d bytes to download.\n",
+		len(t.Install), len(t.Upgrade), len(t.Erase), size)
+}
+
+func mainDownload(c *cli.Context) {
+	checkArgs(c)
+	downloadPackages(c, false)
+}
+
+func mainSource(c *cli.Context) {
+	checkArgs(c)
+	downloadPackages(c, true)
+}
+
+// downloadPackages downloads the packages given on the command line and
+// their dependencies with --resolve, or their source packages.
+func downloadPackages(c *cli.Context, source bool) {
+	cache := loadCache(CacheOptions{Info: source})
+	arch := getArch(c)
+
+	find := func(name string) Packages {
+		res := Packages{}
+		for pkg := range cache.SearchExact([]string{name}, arch, true) {
+			res = append(res, pkg)
+		}
+		return res
+	}
+
+	pkgs := Packages{}
+	for _, name := range c.Args() {
+		found := find(nativeName(name, func(n string) bool { return len(find(n)) > 0 }))
+		if len(found) == 0 {
+			printNotFound(cache, name)
+			os.Exit(1)
+		}
+		pkgs = append(pkgs, found...)
+	}
+
+	if !source && c.Bool("resolve") {
+		names := []string{}
+		for _, pkg := range pkgs {
+			names = append(names, pkg.Name)
+		}
+
+		r := NewResolver(cache, arch)
+		if err := r.Install(names...); err != nil {
+			log.Fatal(err)
+		}
+
+		t := r.Resolve()
+		for _, p := range t.Problems {
+			colorPrintf("{YELLOW}Warning:{NORM} %s\n", p)
+		}
+		pkgs = append(append(pkgs, t.Install...), t.Upgrade...)
+	}
+
+	if source {
+		srcs := Packages{}
+		for _, pkg := range pkgs {
+			src, ok := cache.FindSource(pkg)
+			if !ok {
+				log.Fatalf("Source package %s not found, enable the source media", pkg.Sourcerpm)
+			}
+			srcs = append(srcs, src)
+		}
+		pkgs = srcs
+	}
+
+	// Several binary packages share one source package
+	uniq := Packages{}
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		if !seen[pkg.FileName] {
+			seen[pkg.FileName] = true
+			uniq = append(uniq, pkg)
+		}
+	}
+
+	keyring, err := LoadKeyring(GpgKeyDir, RpmDbDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	d := &Downloader{
+		Dest:        c.String("dest"),
+		MaxParallel: c.Int("max-parallel"),
+		Keyring:     keyring,
+		Progress:    terminal.IsTerminal(int(os.Stdout.Fd())),
+	}
+
+	errs := d.Download(uniq, cache.Repositories())
+
+	if filepath.Clean(d.Dest) == filepath.Clean(PkgCacheDir) {
+		enforceCacheSize()
+	}
+
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func mainClean(c *cli.Context) {
+	policy := CleanPolicy{
+		All:         c.Bool("all"),
+		Old:         c.Bool("old"),
+		Uninstalled: c.Bool("uninstalled"),
+	}
+
+	if !policy.All && !policy.Uninstalled {
+		policy.Old = true
+	}
+
+	var err error
+	if s := c.String("max-size"); s != "" {
+		policy.MaxSize, err = ParseSize(s)
+	} else {
+		policy.MaxSize, err = CacheMaxSize()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files, err := ScanPackageCache(PkgCacheDir)
+	if err != nil {
+		log.Fatal("Can't read package cache: ", err)
+	}
+
+	var cache *Cache
+	if !policy.All {
+		cache = loadCache(CacheOptions{})
+	}
+
+	stale := cache.StaleCachedFiles(files, policy)
+
+	var size, total int64
+	for _, f := range files {
+		total += f.Size
+	}
+
+	for _, f := range stale {
+		fmt.Printf("%-60s %10s  %s\n", filepath.Base(f.Path), humanSize(f.Size), f.Reason)
+		size += f.Size
+	}
+
+	colorPrintf("\n{BOLD}%d of %d files, %s of %s can be freed{NORM}\n",
+		len(stale), len(files), humanSize(size), humanSize(total))
+
+	if c.Bool("dry-run") || len(stale) == 0 {
+		return
+	}
+
+	if !c.Bool("yes") && !askYesNo("Remove the files?") {
+		return
+	}
+
+	freed, err := RemoveCachedFiles(stale)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s freed\n", humanSize(freed))
+}
+
+// askYesNo asks the user a question, the default answer is no.
+func askYesNo(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer := ""
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// enforceCacheSize applies the package cache size limit after downloads.
+func enforceCacheSize() {
+	if err := EnforceCacheSize(); err != nil {
+		colorPrintf("{YELLOW}Warning:{NORM} can't clean package cache: %v\n", err)
+	}
+}
+
+func mainFiles(c *cli.Context) {
+	checkArgs(c)
+	execute(prepend(c.Args(), "urpmf", "-f")...)
+}
+
+func mainFile(c *cli.Context) {
+	checkArgs(c)
+	execute(prepend(c.Args(), "urpmf", "-f")...)
+}
+
+func main() {
+
+	app := cli.NewApp()
+	app.Name = "zrpm"
+	app.Usage = "zrpm is a text-based interface to the RPM package system."
+	app.Version = version
+	app.Author = author
+
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "nocolor",
+			Usage: "Force black and white output",
+		},
+
+		cli.StringFlag{
+			Name:  "dbpath",
+			Value: RpmDbDir,
+			Usage: "Use the rpm database in this directory",
+		},
+
+		cli.BoolFlag{
+			Name:  "skip-broken-media",
+			Usage: "Warn about media which can't be loaded and continue without them",
+		},
+	}
+
+	app.Commands = []cli.Command{
+		// Repos ......................
+		{
+			Name:  "repo",
+			Usage: "Display information about a repositories.",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "Show disabled repositories too.",
+				},
+
+				cli.BoolFlag{
+					Name:  "verify",
+					Usage: "Check the media metadata files against MD5SUM.",
+				},
+			},
+			Action: mainRepo,
+			Subcommands: []cli.Command{
+				{
+					Name:      "add",
+					Usage:     "Add a new media.",
+					ArgsUsage: "NAME URL",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "distrib",
+							Usage: "Add all media of the distribution tree at this URL.",
+						},
+
+						cli.BoolFlag{
+							Name:  "all-media",
+							Usage: "With --distrib, add debug, source and noauto media too.",
+						},
+
+						cli.BoolFlag{
+							Name:  "update",
+							Usage: "Mark the media as an update media.",
+						},
+
+						cli.BoolFlag{
+							Name:  "fetch",
+							Usage: "Download the media metadata immediately.",
+						},
+					},
+					Action: mainRepoAdd,
+				},
+
+				{
+					Name:      "remove",
+					Usage:     "Remove media.",
+					ArgsUsage: "NAME...",
+					Action:    mainRepoRemove,
+				},
+
+				{
+					Name:      "enable",
+					Usage:     "Enable media.",
+					ArgsUsage: "NAME...",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "fetch",
+							Usage: "Download the media metadata immediately.",
+						},
+					},
+					Action: mainRepoEnable,
+				},
+
+				{
+					Name:      "disable",
+					Usage:     "Disable media.",
+					ArgsUsage: "NAME...",
+					Action:    mainRepoDisable,
+				},
+
+				{
+					Name:      "rename",
+					Usage:     "Rename a media.",
+					ArgsUsage: "NAME NEW_NAME",
+					Action:    mainRepoRename,
+				},
+			},
+		},
+
+		// Search .....................
+		{
+			Name:      "search",
 			Aliases:   []string{"s"},
-			Usage:     "Search for a package by name.",
+			Usage:     "Search for a package by name, summary or description.",
 			ArgsUsage: "QUERY...",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name: "arch",
 					Usage: "Comma-separated list of architectures (i586, x86_64, noarch). \n\t" +
@@ -343,7 +1423,33 @@ func main() {
 					Name:  "showduplicates",
 					Usage: "Doesn't limit packages to their latest versions.",
 				},
-			},
+
+				cli.BoolFlag{
+					Name:  "summary",
+					Usage: "Search in the package summaries too.",
+				},
+
+				cli.BoolFlag{
+					Name:  "description",
+					Usage: "Search in the package descriptions too.",
+				},
+
+				cli.BoolFlag{
+					Name:  "all-fields",
+					Usage: "Search in the summaries, descriptions, URLs and groups.",
+				},
+
+				cli.BoolFlag{
+					Name:  "all-terms",
+					Usage: "Show packages matching all terms, by default any term is enough.",
+				},
+
+				cli.StringFlag{
+					Name:  "sort",
+					Value: SortRelevance,
+					Usage: "Sort the results by relevance, name, size or repo.",
+				},
+			}, matchModeFlags...),
 			Action: mainSearch,
 		},
 
@@ -353,6 +1459,42 @@ func main() {
 			Aliases:   []string{"info"},
 			Usage:     "Display detailed information about a package.",
 			ArgsUsage: "QUERY...",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name: "arch",
+					Usage: "Comma-separated list of architectures (i586, x86_64, noarch). \n\t" +
+						"Use 'all' for search packages for any architectures.",
+				},
+
+				cli.BoolFlag{
+					Name:  "showduplicates",
+					Usage: "Doesn't limit packages to their latest versions.",
+				},
+			}, matchModeFlags...),
+			Action: mainShow,
+		},
+
+		// Query RPM file ...................
+		{
+			Name:      "query",
+			Usage:     "Display information about local RPM files.",
+			ArgsUsage: "FILE.rpm...",
+			Action:    mainQuery,
+		},
+
+		// Verify signatures ...............
+		{
+			Name:      "verify-sig",
+			Usage:     "Verify GPG signatures of local RPM files.",
+			ArgsUsage: "FILE.rpm...",
+			Action:    mainVerifySig,
+		},
+
+		// What provides ..................
+		{
+			Name:      "whatprovides",
+			Usage:     "Find packages which provide a capability, soname or file.",
+			ArgsUsage: "CAPABILITY [OPERATOR VERSION]...",
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name: "arch",
@@ -365,7 +1507,37 @@ func main() {
 					Usage: "Doesn't limit packages to their latest versions.",
 				},
 			},
-			Action: mainShow,
+			Action: mainWhatProvides,
+		},
+
+		// What requires ..................
+		{
+			Name:      "whatrequires",
+			Usage:     "List packages which require the given packages.",
+			ArgsUsage: "PACKAGE...",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name: "arch",
+					Usage: "Comma-separated list of architectures (i586, x86_64, noarch). \n\t" +
+						"Use 'all' for search packages for any architectures.",
+				},
+
+				cli.BoolFlag{
+					Name:  "showduplicates",
+					Usage: "Doesn't limit packages to their latest versions.",
+				},
+
+				cli.BoolFlag{
+					Name:  "recursive",
+					Usage: "Show the full reverse-dependency tree.",
+				},
+
+				cli.BoolFlag{
+					Name:  "installed-only",
+					Usage: "Show installed packages only.",
+				},
+			},
+			Action: mainWhatRequires,
 		},
 
 		// Install .........................
@@ -374,7 +1546,18 @@ func main() {
 			Aliases:   []string{"i"},
 			Usage:     "Install/upgrade packages.",
 			ArgsUsage: "PACKAGE...",
-			Action:    mainInstall,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "arch",
+					Usage: "Comma-separated list of architectures (i586, x86_64, noarch).",
+				},
+
+				cli.BoolFlag{
+					Name:  "plan",
+					Usage: "Print the transaction without installing anything.",
+				},
+			},
+			Action: mainInstall,
 		},
 
 		// Remove .........................
@@ -397,7 +1580,18 @@ func main() {
 			Name:    "upgrade",
 			Aliases: []string{"u"},
 			Usage:   "Perform an upgrade, possibly installing and removing packages.",
-			Action:  mainUpgrade,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "arch",
+					Usage: "Comma-separated list of architectures (i586, x86_64, noarch).",
+				},
+
+				cli.BoolFlag{
+					Name:  "plan",
+					Usage: "Print the transaction without upgrading anything.",
+				},
+			},
+			Action: mainUpgrade,
 		},
 
 		// Download RPM ....................
@@ -405,7 +1599,30 @@ func main() {
 			Name:      "download",
 			Usage:     "Download binary RPMs.",
 			ArgsUsage: "PACKAGE...",
-			Action:    mainDownload,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "arch",
+					Usage: "Comma-separated list of architectures (i586, x86_64, noarch).",
+				},
+
+				cli.StringFlag{
+					Name:  "dest",
+					Value: ".",
+					Usage: "Directory to save the files to.",
+				},
+
+				cli.BoolFlag{
+					Name:  "resolve",
+					Usage: "Download the missing dependencies too.",
+				},
+
+				cli.IntFlag{
+					Name:  "max-parallel",
+					Value: 4,
+					Usage: "Maximum number of parallel downloads.",
+				},
+			},
+			Action: mainDownload,
 		},
 
 		// Download SRPM ...................
@@ -413,7 +1630,64 @@ func main() {
 			Name:      "source",
 			Usage:     "Download the source RPMs (SRPMs).",
 			ArgsUsage: "PACKAGE...",
-			Action:    mainSource,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "arch",
+					Usage: "Comma-separated list of architectures (i586, x86_64, noarch).",
+				},
+
+				cli.StringFlag{
+					Name:  "dest",
+					Value: ".",
+					Usage: "Directory to save the files to.",
+				},
+
+				cli.IntFlag{
+					Name:  "max-parallel",
+					Value: 4,
+					Usage: "Maximum number of parallel downloads.",
+				},
+			},
+			Action: mainSource,
+		},
+
+		// Clean package cache ............
+		{
+			Name:  "clean",
+			Usage: "Remove stale packages from the package cache.",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "Remove all cached packages.",
+				},
+
+				cli.BoolFlag{
+					Name:  "old",
+					Usage: "Remove packages which have newer versions (default).",
+				},
+
+				cli.BoolFlag{
+					Name:  "uninstalled",
+					Usage: "Remove packages which are not installed.",
+				},
+
+				cli.StringFlag{
+					Name: "max-size",
+					Usage: "Remove the least recently used packages above this size (e.g. 2G).\n\t" +
+						"The default is max-size from the [cache] section of /etc/zrpm.conf.",
+				},
+
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only show what would be removed.",
+				},
+
+				cli.BoolFlag{
+					Name:  "yes, y",
+					Usage: "Don't ask for confirmation.",
+				},
+			},
+			Action: mainClean,
 		},
 
 		// List files from SRPM ...........
@@ -434,6 +1708,9 @@ func main() {
 		if c.Bool("nocolor") || !terminal.IsTerminal(int(os.Stdout.Fd())) {
 			resetColors()
 		}
+
+		RpmDbDir = c.String("dbpath")
+		skipBrokenMedia = c.Bool("skip-broken-media")
 		return nil
 	}
 
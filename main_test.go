@@ -6,6 +6,8 @@
 package main
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -50,3 +52,83 @@ got:      %v`,
 
 	}
 }
+
+func TestNativeName(t *testing.T) {
+	cases := []struct {
+		name      string
+		available []string
+		expect    string
+	}{
+		{"libfoo", []string{"libfoo", "lib64foo"}, "libfoo"},
+		{"lib64foo", []string{"libfoo", "lib64foo"}, "lib64foo"},
+		{"libfoo", []string{"lib64foo"}, "lib64foo"},
+		{"lib64foo", []string{"libfoo"}, "libfoo"},
+		{"libbar", []string{"libfoo"}, "libbar"},
+	}
+
+	for _, c := range cases {
+		exists := func(name string) bool {
+			for _, a := range c.available {
+				if a == name {
+					return true
+				}
+			}
+			return false
+		}
+
+		if res := nativeName(c.name, exists); res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.name, c.expect, res)
+		}
+	}
+}
+
+func TestPackageLines(t *testing.T) {
+	resetColors()
+
+	kernel := Package{Name: "kernel", Version: "2.0-1", Arch: "x86_64", Summary: "Kernel"}
+	wine := Package{Name: "wine", Version: "1.1-1", Arch: "x86_64", Summary: "Wine"}
+
+	cases := []struct {
+		pkg       Package
+		installed []NEVRA
+		expect    []string
+	}{
+		{
+			kernel,
+			[]NEVRA{{Name: "kernel", Version: "2.0-1", Arch: "x86_64"}},
+			[]string{"I  kernel                                   2.0-1               x86_64   Kernel"},
+		},
+
+		{
+			kernel,
+			[]NEVRA{{Name: "kernel", Version: "1.0-1", Arch: "x86_64"}, {Name: "kernel", Version: "2.0-1", Arch: "x86_64"}},
+			[]string{
+				"I  kernel                                   2.0-1               x86_64   Kernel",
+				"                                 installed: 1.0-1               x86_64",
+				"                                 installed: 2.0-1               x86_64",
+			},
+		},
+
+		{
+			wine,
+			[]NEVRA{{Name: "wine", Version: "1.0-1", Arch: "i586"}},
+			[]string{
+				"   wine                                     1.1-1               x86_64   Wine",
+				"                                 installed: 1.0-1               i586",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c.pkg.Installed = c.installed
+
+		res := []string{}
+		for _, line := range packageLines(nil, c.pkg) {
+			res = append(res, strings.TrimRight(line, " "))
+		}
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.pkg.Name, c.expect, res)
+		}
+	}
+}
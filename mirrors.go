@@ -0,0 +1,328 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// The list urpmi uses for "mirrorlist: $MIRRORLIST"
+	DefaultMirrorlist = "http://api.mandriva.com/mirrors/basic.$RELEASE.$ARCH.list"
+	ProductIdFile     = "/etc/product.id"
+	MirrorCacheDir    = "/var/cache/zrpm/mirrors"
+	MirrorCacheTTL    = 24 * time.Hour
+	MirrorProbeTime   = 3 * time.Second
+)
+
+// mirrorCache is the content of a cached, ranked mirror list.
+type mirrorCache struct {
+	Mirrorlist string
+	Time       time.Time
+	Mirrors    []string
+}
+
+// mirrorVars returns the values for $ARCH, $RELEASE and $HOST. They are
+// taken from /etc/product.id like urpmi does.
+func mirrorVars() map[string]string {
+	res := map[string]string{}
+
+	if data, err := ioutil.ReadFile(ProductIdFile); err == nil {
+		for _, item := range strings.Split(strings.TrimSpace(string(data)), ",") {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) == 2 {
+				res[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+
+	vars := map[string]string{
+		"ARCH":    res["arch"],
+		"RELEASE": res["version"],
+	}
+
+	if vars["ARCH"] == "" {
+		vars["ARCH"] = machineArch()
+		if vars["ARCH"] == "i686" {
+			vars["ARCH"] = "i586"
+		}
+	}
+
+	vars["HOST"], _ = os.Hostname()
+	return vars
+}
+
+// expandMirrorVars substitutes $MIRRORLIST, $ARCH, $RELEASE and $HOST.
+func expandMirrorVars(s string, vars map[string]string) string {
+	s = strings.Replace(s, "$MIRRORLIST", DefaultMirrorlist, -1)
+	for _, k := range []string{"ARCH", "RELEASE", "HOST"} {
+		s = strings.Replace(s, "$"+k, vars[k], -1)
+	}
+	return s
+}
+
+// parseMirrorlist reads a mirror list. Lines are either plain URLs or
+// "key=value,...,url=URL" records.
+func parseMirrorlist(r io.Reader) ([]string, error) {
+	res := []string{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if i := strings.Index(line, "url="); i >= 0 {
+			line = line[i+4:]
+			if j := strings.Index(line, ","); j >= 0 {
+				line = line[:j]
+			}
+		}
+
+		res = append(res, strings.TrimRight(line, "/"))
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("mirror list is empty")
+	}
+	return res, nil
+}
+
+// mirrorLatency returns the time to open a TCP connection to the mirror.
+// Local mirrors are the fastest ones.
+func mirrorLatency(mirror string) (time.Duration, error) {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return 0, err
+	}
+
+	port := map[string]string{"http": "80", "https": "443", "ftp": "21"}[u.Scheme]
+	if port == "" {
+		return 0, nil
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", host, MirrorProbeTime)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+type mirrorProbe struct {
+	mirror  string
+	latency time.Duration
+	ok      bool
+}
+
+type mirrorProbes []mirrorProbe
+
+func (p mirrorProbes) Len() int      { return len(p) }
+func (p mirrorProbes) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p mirrorProbes) Less(i, j int) bool {
+	if p[i].ok != p[j].ok {
+		return p[i].ok
+	}
+	return p[i].latency < p[j].latency
+}
+
+// rankMirrors sorts the mirrors by latency, unreachable ones go last.
+func rankMirrors(mirrors []string) []string {
+	probes := make(mirrorProbes, len(mirrors))
+	sem := make(chan bool, 16)
+	var wg sync.WaitGroup
+
+	for i, m := range mirrors {
+		wg.Add(1)
+		go func(i int, m string) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+
+			latency, err := mirrorLatency(m)
+			probes[i] = mirrorProbe{m, latency, err == nil}
+		}(i, m)
+	}
+	wg.Wait()
+
+	sort.Stable(probes)
+
+	res := make([]string, len(probes))
+	for i, p := range probes {
+		res[i] = p.mirror
+	}
+	return res
+}
+
+func mirrorCacheFile(mirrorlist string) string {
+	sum := md5.Sum([]byte(mirrorlist))
+	return MirrorCacheDir + "/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+func readMirrorCache(mirrorlist string) *mirrorCache {
+	data, err := ioutil.ReadFile(mirrorCacheFile(mirrorlist))
+	if err != nil {
+		return nil
+	}
+
+	res := &mirrorCache{}
+	if json.Unmarshal(data, res) != nil || res.Mirrorlist != mirrorlist || len(res.Mirrors) == 0 {
+		return nil
+	}
+	return res
+}
+
+// writeMirrorCache saves the ranked list. The cache is an optimization, so
+// errors (for example for non-root users) are ignored.
+func writeMirrorCache(c *mirrorCache) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if os.MkdirAll(MirrorCacheDir, 0755) != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(MirrorCacheDir, ".mirrors-")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil || os.Rename(tmp.Name(), mirrorCacheFile(c.Mirrorlist)) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// ResolveMirrorlist returns the mirrors of the list, the fastest first.
+// The ranked list is cached for MirrorCacheTTL.
+func ResolveMirrorlist(mirrorlist string) ([]string, error) {
+	mirrorlist = expandMirrorVars(mirrorlist, mirrorVars())
+
+	if c := readMirrorCache(mirrorlist); c != nil && time.Since(c.Time) < MirrorCacheTTL {
+		return c.Mirrors, nil
+	}
+
+	r, err := OpenURL(mirrorlist)
+	if err != nil {
+		return nil, fmt.Errorf("Can't download mirror list: %v", err)
+	}
+	defer r.Close()
+
+	mirrors, err := parseMirrorlist(r)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read mirror list %s: %v", mirrorlist, err)
+	}
+
+	mirrors = rankMirrors(mirrors)
+	writeMirrorCache(&mirrorCache{Mirrorlist: mirrorlist, Time: time.Now(), Mirrors: mirrors})
+	return mirrors, nil
+}
+
+// preferMirror moves the mirror that worked to the top of the cached list,
+// so the next operations start with it.
+func preferMirror(mirrorlist, mirror string) {
+	mirrorlist = expandMirrorVars(mirrorlist, mirrorVars())
+
+	c := readMirrorCache(mirrorlist)
+	if c == nil || c.Mirrors[0] == mirror {
+		return
+	}
+
+	mirrors := []string{mirror}
+	for _, m := range c.Mirrors {
+		if m != mirror {
+			mirrors = append(mirrors, m)
+		}
+	}
+
+	c.Mirrors = mirrors
+	writeMirrorCache(c)
+}
+
+// BaseURLs returns the URLs of the media to try in order: the fixed URL
+// or the ranked mirrors of the mirror list.
+func (rep Repository) BaseURLs() ([]string, error) {
+	urls, _, err := rep.baseURLs()
+	return urls, err
+}
+
+func (rep Repository) baseURLs() (urls []string, mirrors []string, err error) {
+	if rep.URL != "" {
+		return []string{rep.URL}, nil, nil
+	}
+
+	if rep.Mirrorlist == "" {
+		return nil, nil, fmt.Errorf("media %s has neither URL nor mirrorlist", rep.Name)
+	}
+
+	mirrors, err = ResolveMirrorlist(rep.Mirrorlist)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	withDir := expandMirrorVars(rep.WithDir, mirrorVars())
+	for _, m := range mirrors {
+		if withDir != "" {
+			m += "/" + strings.Trim(withDir, "/")
+		}
+		urls = append(urls, m)
+	}
+	return urls, mirrors, nil
+}
+
+// WithMirrors calls fn with the base URLs of the media until it succeeds.
+// A mirror that worked becomes the preferred one. The URL that worked is
+// returned.
+func (rep Repository) WithMirrors(fn func(baseURL string) error) (string, error) {
+	urls, mirrors, err := rep.baseURLs()
+	if err != nil {
+		return "", err
+	}
+
+	errs := []string{}
+	for i, u := range urls {
+		err := fn(u)
+		if err == nil {
+			if i > 0 && mirrors != nil {
+				preferMirror(rep.Mirrorlist, mirrors[i])
+			}
+			return u, nil
+		}
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) == 1 {
+		return "", fmt.Errorf("%s", errs[0])
+	}
+	return "", fmt.Errorf("all %d mirrors failed:\n    %s", len(errs), strings.Join(errs, "\n    "))
+}
@@ -0,0 +1,135 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMirrorlist(t *testing.T) {
+	list := `# Mirrors
+continent=EU,zone=RU,country=Russia,city=Moscow,latitude=55.75,longitude=37.62,type=distrib,url=http://mirror.yandex.ru/rosa/
+ftp://ftp.example.com/pub/rosa
+
+url=file:///mnt/rosa
+`
+	expect := []string{"http://mirror.yandex.ru/rosa", "ftp://ftp.example.com/pub/rosa", "file:///mnt/rosa"}
+
+	res, err := parseMirrorlist(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, list)
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, list, expect, res)
+	}
+
+	if _, err := parseMirrorlist(strings.NewReader("# empty\n")); err == nil {
+		t.Errorf("Empty mirror list must be rejected")
+	}
+}
+
+func TestExpandMirrorVars(t *testing.T) {
+	vars := map[string]string{"ARCH": "x86_64", "RELEASE": "2014.1", "HOST": "box"}
+	DefaultMirrorlist = "http://example.com/$RELEASE.$ARCH.list"
+
+	cases := []struct {
+		from   string
+		expect string
+	}{
+		{"$MIRRORLIST", "http://example.com/2014.1.x86_64.list"},
+		{"http://example.com/list?host=$HOST", "http://example.com/list?host=box"},
+		{"media/$ARCH/main", "media/x86_64/main"},
+	}
+
+	for _, c := range cases {
+		if res := expandMirrorVars(c.from, vars); res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.from, c.expect, res)
+		}
+	}
+}
+
+func TestMirrorFailover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	MirrorCacheDir = dir + "/mirrors"
+	ProductIdFile = dir + "/product.id"
+	ioutil.WriteFile(ProductIdFile, []byte("vendor=ROSA,version=2014.1,arch=x86_64\n"), 0644)
+
+	media := &testMedia{files: map[string]string{"synthesis.hdlist.cz": "synthesis"}}
+	good := httptest.NewServer(media)
+	defer good.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	lists := 0
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2014.1/x86_64.list" {
+			http.NotFound(w, r)
+			return
+		}
+		lists++
+		fmt.Fprintf(w, "url=%s\nurl=%s\nurl=%s\n", dead.URL, broken.URL, good.URL)
+	}))
+	defer list.Close()
+
+	rep := Repository{
+		Name:       "main",
+		Mirrorlist: list.URL + "/$RELEASE/$ARCH.list",
+		WithDir:    "media",
+		Dir:        dir + "/main",
+	}
+
+	urls, err := rep.BaseURLs()
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Mirrorlist)
+	}
+
+	if len(urls) != 3 || urls[2] != dead.URL+"/media" {
+		t.Errorf(TMPL_MISMATCH, "dead mirror must be the last", dead.URL+"/media", urls)
+	}
+
+	if _, err := UpdateRepository(rep); err != nil {
+		t.Fatalf(TMPL_ERROR, err, rep.Name)
+	}
+
+	if res, _ := ioutil.ReadFile(rep.Dir + "/synthesis.hdlist.cz"); string(res) != "synthesis" {
+		t.Errorf(TMPL_MISMATCH, "synthesis.hdlist.cz", "synthesis", string(res))
+	}
+
+	// The mirror that worked is cached as the preferred one
+	urls, err = rep.BaseURLs()
+	if err != nil || urls[0] != good.URL+"/media" {
+		t.Errorf(TMPL_MISMATCH, "preferred mirror", good.URL+"/media", urls)
+	}
+
+	if lists != 1 {
+		t.Errorf(TMPL_MISMATCH, "mirror list downloads", 1, lists)
+	}
+
+	// All mirrors are broken
+	good.Close()
+	if _, err := UpdateRepository(rep); err == nil || !strings.Contains(err.Error(), "all 3 mirrors failed") {
+		t.Errorf(TMPL_MISMATCH, "all mirrors are broken", "all 3 mirrors failed", err)
+	}
+}
@@ -7,6 +7,7 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -26,6 +27,7 @@ type Package struct {
 	License     string // from info
 	Description string // from info
 	Arch        string // from synthesis
+	Epoch       int    // from synthesis
 	Version     string // from synthesis
 	Summary     string // from synthesis
 	Size        int    // from synthesis
@@ -33,60 +35,267 @@ type Package struct {
 	Group       string // from synthesis
 	Repository  string // from synthesis
 
-	InstalledVer string
+	Provides  []Dependency // from synthesis
+	Requires  []Dependency // from synthesis
+	Conflicts []Dependency // from synthesis
+	Obsoletes []Dependency // from synthesis
+	Suggests  []Dependency // from synthesis
+
+	Installed []NEVRA // all installed instances with the same name
 }
 
-func NewPackage() Package {
-	return Package{}
+// The architectures whose libraries are packaged as lib64*
+var lib64Arches = []string{"x86_64", "aarch64", "ppc64", "ppc64le", "s390x", "riscv64"}
+
+func isLib64Arch(arch string) bool {
+	for _, a := range lib64Arches {
+		if a == arch {
+			return true
+		}
+	}
+	return false
 }
 
-func (p Package) State() int {
-	if p.InstalledVer == "" {
-		return PACKAGE_NOTINSATALLED
+// libNameVariants returns the spellings of the name for both library
+// ABIs, the variant native for the arch first. For libfoo-devel on x86_64
+// these are lib64foo-devel and libfoo-devel. Other names are returned
+// as is.
+func libNameVariants(name string, arch string) []string {
+	var base string
+	switch {
+	case strings.HasPrefix(name, "lib64"):
+		base = name[5:]
+	case strings.HasPrefix(name, "lib"):
+		base = name[3:]
+	default:
+		return []string{name}
 	}
 
-	if CompareVer(p.InstalledVer, p.Version) < 0 {
-		return PACKAGE_UPDATE
+	if isLib64Arch(arch) {
+		return []string{"lib64" + base, "lib" + base}
 	}
+	return []string{"lib" + base, "lib64" + base}
+}
 
-	return PACKAGE_INSATALLED
+// RPMFileName returns the name of the package file on the media.
+func (p Package) RPMFileName() string {
+	return p.FileName + ".rpm"
 }
 
-func CompareVer(ver1, ver2 string) int {
-	ver1 += "."
-	ver2 += "."
-	res := 0
-	s1 := ""
-	s2 := ""
-	for res == 0 {
+// NEVRA describes an installed package instance.
+type NEVRA struct {
+	Name    string
+	Epoch   int
+	Version string // version-release
+	Arch    string
+}
+
+// EVR returns the parsed version of the installed package.
+func (n NEVRA) EVR() EVR {
+	res := ParseEVR(n.Version)
+	res.Epoch = n.Epoch
+	return res
+}
+
+// FullVersion returns "epoch:version-release", the epoch is omitted if
+// it is 0.
+func (n NEVRA) FullVersion() string {
+	if n.Epoch == 0 {
+		return n.Version
+	}
+	return strconv.Itoa(n.Epoch) + ":" + n.Version
+}
 
-		n1 := strings.IndexAny(ver1, ".-")
-		n2 := strings.IndexAny(ver2, ".-")
+// Dependency is a single provides/requires/conflicts/obsoletes/suggests
+// entry. Flags is one of "", "==", "<", "<=", ">", ">=".
+type Dependency struct {
+	Name  string
+	Flags string
+	EVR   string
+}
 
-		if n1 > -1 {
-			s1 = fmt.Sprintf("%010s", ver1[:n1])
-			ver1 = ver1[n1+1:]
-		} else {
-			s1 = "0000000000"
+func (d Dependency) String() string {
+	if d.Flags == "" {
+		return d.Name
+	}
+	return d.Name + " " + d.Flags + " " + d.EVR
+}
+
+// ParseDependency parses a user query like "libfoo", "libfoo >= 1.2" or
+// "libfoo>=1.2".
+func ParseDependency(s string) (Dependency, error) {
+	s = strings.TrimSpace(s)
+	n := strings.IndexAny(s, "<>=")
+	if n < 0 {
+		return Dependency{Name: s}, nil
+	}
+
+	dep := Dependency{Name: strings.TrimSpace(s[:n])}
+	rest := s[n:]
+	m := strings.IndexFunc(rest, func(r rune) bool {
+		return r != '<' && r != '>' && r != '='
+	})
+	if m < 0 {
+		return dep, fmt.Errorf("version expected in '%s'", s)
+	}
+
+	dep.Flags = rest[:m]
+	dep.EVR = strings.TrimSpace(rest[m:])
+	if dep.Flags == "=" {
+		dep.Flags = "=="
+	}
+
+	switch dep.Flags {
+	case "==", "<", "<=", ">", ">=":
+	default:
+		return dep, fmt.Errorf("unknown operator '%s' in '%s'", dep.Flags, s)
+	}
+
+	if dep.Name == "" || dep.EVR == "" {
+		return dep, fmt.Errorf("incorrect dependency '%s'", s)
+	}
+
+	return dep, nil
+}
+
+// Overlaps reports whether the version ranges of two dependencies with the
+// same name intersect, like rpmRangesOverlap does. A dependency without
+// flags matches any version.
+func (d Dependency) Overlaps(o Dependency) bool {
+	if d.Name != o.Name {
+		return false
+	}
+
+	if d.Flags == "" || o.Flags == "" {
+		return true
+	}
+
+	dLess := strings.Contains(d.Flags, "<")
+	dGreater := strings.Contains(d.Flags, ">")
+	dEqual := strings.Contains(d.Flags, "=")
+	oLess := strings.Contains(o.Flags, "<")
+	oGreater := strings.Contains(o.Flags, ">")
+	oEqual := strings.Contains(o.Flags, "=")
+
+	sense := CompareEVR(d.EVR, o.EVR)
+	switch {
+	case sense < 0:
+		return dGreater || oLess
+	case sense > 0:
+		return dLess || oGreater
+	default:
+		return (dEqual && oEqual) || (dLess && oLess) || (dGreater && oGreater)
+	}
+}
+
+// ProvidesDep reports whether the package has a provide matching dep. The
+// package's own name and version are always provided.
+func (p Package) ProvidesDep(dep Dependency) bool {
+	self := Dependency{Name: p.Name, Flags: "==", EVR: p.FullVersion()}
+	if self.Overlaps(dep) {
+		return true
+	}
+
+	for _, prov := range p.Provides {
+		if prov.Overlaps(dep) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewPackage() Package {
+	return Package{}
+}
+
+// InstalledInstances returns installed instances matching the package arch.
+// A noarch package matches instances of any arch and vice versa, so a
+// package which changed its arch is still recognized.
+func (p Package) InstalledInstances() []NEVRA {
+	res := []NEVRA{}
+	for _, n := range p.Installed {
+		if n.Arch == p.Arch {
+			res = append(res, n)
+		}
+	}
+
+	if len(res) > 0 {
+		return res
+	}
+
+	for _, n := range p.Installed {
+		if n.Arch == "noarch" || p.Arch == "noarch" {
+			res = append(res, n)
 		}
+	}
 
-		if n2 > -1 {
-			s2 = fmt.Sprintf("%010s", ver2[:n2])
-			ver2 = ver2[n2+1:]
-		} else {
+	return res
+}
 
-			s2 = "0000000000"
+// InstalledVersion returns the newest installed version matching the
+// package arch, or "" if the package is not installed.
+func (p Package) InstalledVersion() string {
+	var last *NEVRA
+	for _, n := range p.InstalledInstances() {
+		if last == nil || n.EVR().Compare(last.EVR()) > 0 {
+			n := n
+			last = &n
 		}
+	}
 
-		res = strings.Compare(s1, s2)
-		if n1 < 0 && n2 < 0 {
-			break
+	if last == nil {
+		return ""
+	}
+	return last.FullVersion()
+}
+
+// IsInstalled reports whether exactly this version of the package is
+// installed.
+func (p Package) IsInstalled() bool {
+	for _, n := range p.InstalledInstances() {
+		if n.Arch == p.Arch && n.EVR().Compare(p.EVR()) == 0 {
+			return true
 		}
 	}
+	return false
+}
+
+func (p Package) State() int {
+	ver := p.InstalledVersion()
+	if ver == "" {
+		return PACKAGE_NOTINSATALLED
+	}
+
+	if ParseEVR(ver).Compare(p.EVR()) < 0 {
+		return PACKAGE_UPDATE
+	}
+
+	return PACKAGE_INSATALLED
+}
 
+// EVR returns the parsed version of the package.
+func (p Package) EVR() EVR {
+	res := ParseEVR(p.Version)
+	res.Epoch = p.Epoch
+	res.Distepoch = p.Distepoch
 	return res
 }
 
+// FullVersion returns "epoch:version-release", the epoch is omitted if
+// it is 0.
+func (p Package) FullVersion() string {
+	if p.Epoch == 0 {
+		return p.Version
+	}
+	return strconv.Itoa(p.Epoch) + ":" + p.Version
+}
+
+// CompareVersion compares versions of two packages.
+func (p Package) CompareVersion(o Package) int {
+	return p.EVR().Compare(o.EVR())
+}
+
 type Packages []Package
 
 func (p Packages) Len() int {
@@ -6,45 +6,110 @@
 package main
 
 import (
+	"reflect"
 	"testing"
 )
 
-func TestCompareVer(t *testing.T) {
+func TestDependencyOverlaps(t *testing.T) {
 	cases := []struct {
-		ver1   string
-		ver2   string
+		provide string
+		require string
+		expect  bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"foo == 1.0", "foo", true},
+		{"foo", "foo >= 1.0", true},
+		{"foo == 1.0", "foo >= 1.0", true},
+		{"foo == 1.0", "foo > 1.0", false},
+		{"foo == 1.0-1", "foo >= 1.0-2", false},
+		{"foo == 1.0-1", "foo >= 1.0", true},
+		{"foo == 1.0-1:2014.1", "foo < 1.1", true},
+		{"foo == 1:0.5", "foo >= 1.0", true},
+		{"foo < 2.0", "foo > 1.0", true},
+		{"foo < 1.0", "foo > 1.0", false},
+		{"foo <= 1.0", "foo >= 1.0", true},
+	}
+
+	for _, c := range cases {
+		p, err := ParseDependency(c.provide)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.provide)
+			continue
+		}
+
+		r, err := ParseDependency(c.require)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.require)
+			continue
+		}
+
+		if res := p.Overlaps(r); res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.provide+" vs "+c.require, c.expect, res)
+		}
+	}
+}
+
+func TestPackageState(t *testing.T) {
+	cases := []struct {
+		pkg    Package
 		expect int
 	}{
-		{"", "", 0},
-		{"", "0", 0},
-		{"", "1", -1},
-		{"2", "", 1},
-		{"0.1", "0.1", 0},
-		{"0.1", "0.1.0", 0},
-		{"0.1", "0.1.1", -1},
-		{"0.2", "0.1.1", 1},
-		{"0.10.0", "0.9.9", 1},
-		{"0.10.0", "0.009.9", 1},
-		{"0.1.1", "0.1.9", -1},
-		{"git.0.2", "git.0.1.1", 1},
+		{Package{Version: "1.0-1", Arch: "x86_64"}, PACKAGE_NOTINSATALLED},
+		{Package{Version: "1.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "x86_64"}}}, PACKAGE_INSATALLED},
+		{Package{Version: "1.0-2", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "x86_64"}}}, PACKAGE_UPDATE},
+		{Package{Version: "1.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "1.0-2", Arch: "x86_64"}}}, PACKAGE_INSATALLED},
+		{Package{Epoch: 1, Version: "1.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "2.0-1", Arch: "x86_64"}}}, PACKAGE_UPDATE},
+		{Package{Epoch: 1, Version: "1.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Epoch: 1, Version: "1.0-1", Arch: "x86_64"}}}, PACKAGE_INSATALLED},
+		{Package{Version: "2.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Epoch: 1, Version: "1.0-1", Arch: "x86_64"}}}, PACKAGE_INSATALLED},
+
+		// Multilib: only the instance with the same arch is taken into account
+		{Package{Version: "1.0-2", Arch: "i586",
+			Installed: []NEVRA{{Version: "1.0-2", Arch: "x86_64"}}}, PACKAGE_NOTINSATALLED},
+		{Package{Version: "1.0-2", Arch: "i586",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "i586"}, {Version: "1.0-2", Arch: "x86_64"}}}, PACKAGE_UPDATE},
+		{Package{Version: "1.0-2", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "i586"}, {Version: "1.0-2", Arch: "x86_64"}}}, PACKAGE_INSATALLED},
+
+		// Several kernels: the newest one wins
+		{Package{Version: "4.1-2", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "4.1-2", Arch: "x86_64"}, {Version: "4.1-1", Arch: "x86_64"}}}, PACKAGE_INSATALLED},
+
+		// The package changed its arch to noarch
+		{Package{Version: "1.0-2", Arch: "noarch",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "x86_64"}}}, PACKAGE_UPDATE},
 	}
 
 	for _, c := range cases {
-		res := CompareVer(c.ver1, c.ver2)
-
-		if res != c.expect {
-
-			t.Errorf(`Result mismatch
-------------------------
-ver1: %#v
-ver2: %#v
-expected: %v
-got:      %v`,
-				c.ver1,
-				c.ver2,
-				c.expect, res)
-			return
+		if res := c.pkg.State(); res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.pkg, c.expect, res)
 		}
+	}
+}
 
+func TestLibNameVariants(t *testing.T) {
+	cases := []struct {
+		name   string
+		arch   string
+		expect []string
+	}{
+		{"libfoo-devel", "x86_64", []string{"lib64foo-devel", "libfoo-devel"}},
+		{"lib64foo-devel", "x86_64", []string{"lib64foo-devel", "libfoo-devel"}},
+		{"libfoo-devel", "i586", []string{"libfoo-devel", "lib64foo-devel"}},
+		{"lib64foo-devel", "i586", []string{"libfoo-devel", "lib64foo-devel"}},
+		{"libfoo1", "aarch64", []string{"lib64foo1", "libfoo1"}},
+		{"flacon", "x86_64", []string{"flacon"}},
+	}
+
+	for _, c := range cases {
+		if res := libNameVariants(c.name, c.arch); !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.name+"."+c.arch, c.expect, res)
+		}
 	}
 }
@@ -0,0 +1,279 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gopkg.in/ini.v1"
+)
+
+var (
+	PkgCacheDir  = "/var/cache/urpmi/rpms"
+	ZrpmConfFile = "/etc/zrpm.conf"
+
+	// Partial downloads are resumed by the downloader, only the ones not
+	// touched for this long are removed without the All policy.
+	PartialMaxAge = 7 * 24 * time.Hour
+)
+
+// CachedFile is a file in the package cache directory.
+type CachedFile struct {
+	Path   string
+	Size   int64
+	Used   time.Time // last access time
+	Reason string    // why the file can be removed
+
+	pkg      NEVRA
+	broken   bool
+	partial  bool      // *.rpm.part left by an interrupted download
+	modified time.Time // last write, the partial files are appended to
+}
+
+// CleanPolicy selects the files to remove from the package cache.
+type CleanPolicy struct {
+	All         bool  // all files
+	Old         bool  // files with newer versions in the media or installed
+	Uninstalled bool  // files of packages which are not installed
+	MaxSize     int64 // evict least recently used files above this size
+}
+
+// ScanPackageCache lists the package files in dir. The package of every
+// file is read from its header.
+func ScanPackageCache(dir string) ([]CachedFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := []CachedFile{}
+	for _, fi := range infos {
+		if fi.IsDir() || !(strings.HasSuffix(fi.Name(), ".rpm") || strings.HasSuffix(fi.Name(), ".rpm.part")) {
+			continue
+		}
+
+		f := CachedFile{
+			Path:     filepath.Join(dir, fi.Name()),
+			Size:     fi.Size(),
+			Used:     fi.ModTime(),
+			modified: fi.ModTime(),
+		}
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			f.Used = time.Unix(st.Atim.Sec, st.Atim.Nsec)
+		}
+
+		if strings.HasSuffix(fi.Name(), ".part") {
+			f.partial = true
+		} else if rpm, err := ReadRPMFile(f.Path); err != nil {
+			f.broken = true
+		} else {
+			f.pkg = rpm.Header.NEVRA()
+		}
+
+		res = append(res, f)
+	}
+
+	return res, nil
+}
+
+// StaleCachedFiles returns the files to be removed by the policy. The
+// cache is only used for the Old and Uninstalled policies.
+func (c *Cache) StaleCachedFiles(files []CachedFile, policy CleanPolicy) []CachedFile {
+	newest := map[string]EVR{}
+	if c != nil {
+		update := func(name, arch string, evr EVR) {
+			if cur, ok := newest[name+"."+arch]; !ok || evr.Compare(cur) > 0 {
+				newest[name+"."+arch] = evr
+			}
+		}
+
+		for _, pkg := range c.packages {
+			update(pkg.Name, pkg.Arch, pkg.EVR())
+		}
+
+		for _, inst := range c.installed {
+			for _, n := range inst {
+				update(n.Name, n.Arch, n.EVR())
+			}
+		}
+	}
+
+	isInstalled := func(n NEVRA) bool {
+		if c == nil {
+			return false
+		}
+
+		for _, i := range c.installed[n.Name] {
+			if i.Arch == n.Arch && i.EVR().Compare(n.EVR()) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	res := []CachedFile{}
+	keep := []CachedFile{}
+	for _, f := range files {
+		switch {
+		case f.partial && policy.All:
+			f.Reason = "partial"
+
+		case f.partial && time.Since(f.modified) > PartialMaxAge:
+			f.Reason = "stale partial"
+
+		case f.partial:
+			// Keep it for resuming, evictLRU skips it too
+
+		case f.broken:
+			f.Reason = "broken"
+
+		case policy.All:
+			f.Reason = "all"
+
+		case policy.Old && newest[f.pkg.Name+"."+f.pkg.Arch].Compare(f.pkg.EVR()) > 0:
+			f.Reason = "old"
+
+		case policy.Uninstalled && !isInstalled(f.pkg):
+			f.Reason = "not installed"
+		}
+
+		if f.Reason != "" {
+			res = append(res, f)
+		} else {
+			keep = append(keep, f)
+		}
+	}
+
+	return append(res, evictLRU(keep, policy.MaxSize)...)
+}
+
+type cachedFilesByUse []CachedFile
+
+func (f cachedFilesByUse) Len() int           { return len(f) }
+func (f cachedFilesByUse) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f cachedFilesByUse) Less(i, j int) bool { return f[i].Used.Before(f[j].Used) }
+
+// evictLRU returns the least recently used files to remove to fit maxSize.
+func evictLRU(files []CachedFile, maxSize int64) []CachedFile {
+	if maxSize <= 0 {
+		return nil
+	}
+
+	// Partial downloads are kept for resuming
+	var size int64
+	sorted := cachedFilesByUse{}
+	for _, f := range files {
+		if !f.partial {
+			size += f.Size
+			sorted = append(sorted, f)
+		}
+	}
+	sort.Stable(sorted)
+
+	res := []CachedFile{}
+	for _, f := range sorted {
+		if size <= maxSize {
+			break
+		}
+
+		f.Reason = "cache size limit"
+		res = append(res, f)
+		size -= f.Size
+	}
+	return res
+}
+
+// RemoveCachedFiles deletes the files and returns the freed space.
+func RemoveCachedFiles(files []CachedFile) (int64, error) {
+	var res int64
+	for _, f := range files {
+		if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			return res, err
+		}
+		res += f.Size
+	}
+	return res, nil
+}
+
+// EnforceCacheSize removes the least recently used files from the package
+// cache if it is larger than the configured limit.
+func EnforceCacheSize() error {
+	max, err := CacheMaxSize()
+	if err != nil || max <= 0 {
+		return err
+	}
+
+	files, err := ScanPackageCache(PkgCacheDir)
+	if err != nil {
+		return err
+	}
+
+	_, err = RemoveCachedFiles(evictLRU(files, max))
+	return err
+}
+
+// CacheMaxSize returns the "max-size" from the [cache] section of
+// /etc/zrpm.conf, 0 means no limit.
+func CacheMaxSize() (int64, error) {
+	if !fileExists(ZrpmConfFile) {
+		return 0, nil
+	}
+
+	cfg, err := ini.Load(ZrpmConfFile)
+	if err != nil {
+		return 0, fmt.Errorf("Can't read %s: %v", ZrpmConfFile, err)
+	}
+
+	s := cfg.Section("cache").Key("max-size").String()
+	if s == "" {
+		return 0, nil
+	}
+
+	res, err := ParseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("Can't read %s: %v", ZrpmConfFile, err)
+	}
+	return res, nil
+}
+
+// ParseSize parses sizes like "1500", "300K", "20M" or "2G".
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1024
+		case 'M':
+			mult = 1024 * 1024
+		case 'G':
+			mult = 1024 * 1024 * 1024
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+
+	res, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || res < 0 {
+		return 0, fmt.Errorf("incorrect size '%s'", s)
+	}
+	return int64(res * float64(mult)), nil
+}
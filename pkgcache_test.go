@@ -0,0 +1,184 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func writeCachedRPM(t *testing.T, dir, name, version, release string, used time.Time) {
+	header := encodeRPMHeader([]testTag{
+		{RPMTAG_NAME, RPM_STRING_TYPE, name},
+		{RPMTAG_VERSION, RPM_STRING_TYPE, version},
+		{RPMTAG_RELEASE, RPM_STRING_TYPE, release},
+		{RPMTAG_ARCH, RPM_STRING_TYPE, "x86_64"},
+		{RPMTAG_SOURCERPM, RPM_STRING_TYPE, name + "-" + version + "-" + release + ".src.rpm"},
+	})
+
+	file := dir + "/" + name + "-" + version + "-" + release + ".x86_64.rpm"
+	if err := ioutil.WriteFile(file, buildTestRPM(RPMLEAD_BINARY, encodeRPMHeader(nil), header, []byte("payload")), 0644); err != nil {
+		t.Fatal("Can't create file:", err)
+	}
+	os.Chtimes(file, used, used)
+}
+
+func cleanResult(files []CachedFile) []string {
+	res := []string{}
+	for _, f := range files {
+		res = append(res, filepath.Base(f.Path)+" "+f.Reason)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestStaleCachedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	writeCachedRPM(t, dir, "foo", "1.0", "1", now.Add(-3*time.Hour))
+	writeCachedRPM(t, dir, "foo", "2.0", "1", now.Add(-2*time.Hour))
+	writeCachedRPM(t, dir, "bar", "1.0", "1", now.Add(-1*time.Hour))
+	ioutil.WriteFile(dir+"/broken-1.0-1.x86_64.rpm", []byte("broken"), 0644)
+	ioutil.WriteFile(dir+"/baz-1.0-1.x86_64.rpm.part", []byte("partial"), 0644)
+	ioutil.WriteFile(dir+"/qux-1.0-1.x86_64.rpm.part", []byte("partial"), 0644)
+	os.Chtimes(dir+"/qux-1.0-1.x86_64.rpm.part", now, now.Add(-PartialMaxAge-time.Hour))
+	ioutil.WriteFile(dir+"/README", []byte("readme"), 0644)
+
+	files, err := ScanPackageCache(dir)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+
+	if len(files) != 6 {
+		t.Fatalf(TMPL_MISMATCH, "files", 6, len(files))
+	}
+
+	cache := &Cache{
+		packages: Packages{
+			{Name: "foo", Version: "2.0-1", Arch: "x86_64"},
+			{Name: "bar", Version: "1.0-1", Arch: "x86_64"},
+		},
+		installed: map[string][]NEVRA{
+			"foo": {{Name: "foo", Version: "2.0-1", Arch: "x86_64"}},
+		},
+	}
+
+	// The size of a test rpm file
+	size := files[0].Size
+	for _, f := range files {
+		if f.Path == dir+"/foo-2.0-1.x86_64.rpm" {
+			size = f.Size
+		}
+	}
+
+	cases := []struct {
+		policy CleanPolicy
+		expect []string
+	}{
+		{
+			CleanPolicy{Old: true},
+			[]string{"broken-1.0-1.x86_64.rpm broken", "foo-1.0-1.x86_64.rpm old", "qux-1.0-1.x86_64.rpm.part stale partial"},
+		},
+
+		{
+			CleanPolicy{Uninstalled: true},
+			[]string{"bar-1.0-1.x86_64.rpm not installed", "broken-1.0-1.x86_64.rpm broken",
+				"foo-1.0-1.x86_64.rpm not installed", "qux-1.0-1.x86_64.rpm.part stale partial"},
+		},
+
+		{
+			CleanPolicy{All: true},
+			[]string{"bar-1.0-1.x86_64.rpm all", "baz-1.0-1.x86_64.rpm.part partial", "broken-1.0-1.x86_64.rpm broken",
+				"foo-1.0-1.x86_64.rpm all", "foo-2.0-1.x86_64.rpm all", "qux-1.0-1.x86_64.rpm.part partial"},
+		},
+
+		{
+			CleanPolicy{Old: true, MaxSize: size},
+			[]string{"broken-1.0-1.x86_64.rpm broken", "foo-1.0-1.x86_64.rpm old",
+				"foo-2.0-1.x86_64.rpm cache size limit", "qux-1.0-1.x86_64.rpm.part stale partial"},
+		},
+	}
+
+	for _, c := range cases {
+		res := cleanResult(cache.StaleCachedFiles(files, c.policy))
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.policy, c.expect, res)
+		}
+	}
+
+	stale := cache.StaleCachedFiles(files, CleanPolicy{All: true})
+	if _, err := RemoveCachedFiles(stale); err != nil {
+		t.Errorf(TMPL_ERROR, err, dir)
+	}
+
+	if files, _ := ScanPackageCache(dir); len(files) != 0 {
+		t.Errorf(TMPL_MISMATCH, "after clean", 0, len(files))
+	}
+}
+
+func TestEnforceCacheSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	PkgCacheDir = dir + "/rpms"
+	ZrpmConfFile = dir + "/zrpm.conf"
+	os.MkdirAll(PkgCacheDir, 0755)
+	ioutil.WriteFile(ZrpmConfFile, []byte("[cache]\nmax-size = 10\n"), 0644)
+
+	writeCachedRPM(t, PkgCacheDir, "foo", "1.0", "1", time.Now())
+	ioutil.WriteFile(PkgCacheDir+"/bar-1.0-1.x86_64.rpm.part", bytes.Repeat([]byte("p"), 100), 0644)
+
+	if err := EnforceCacheSize(); err != nil {
+		t.Fatalf(TMPL_ERROR, err, PkgCacheDir)
+	}
+
+	files, _ := ScanPackageCache(PkgCacheDir)
+	if len(files) != 1 || filepath.Base(files[0].Path) != "bar-1.0-1.x86_64.rpm.part" {
+		t.Errorf(TMPL_MISMATCH, "after eviction", "bar-1.0-1.x86_64.rpm.part", cleanResult(files))
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		str    string
+		expect int64
+	}{
+		{"1500", 1500},
+		{"300K", 300 * 1024},
+		{"20 MB", 20 * 1024 * 1024},
+		{"1.5g", 1536 * 1024 * 1024},
+	}
+
+	for _, c := range cases {
+		res, err := ParseSize(c.str)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.str)
+			continue
+		}
+
+		if res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.str, c.expect, res)
+		}
+	}
+
+	if _, err := ParseSize("big"); err == nil {
+		t.Errorf("Incorrect size must be rejected")
+	}
+}
@@ -6,11 +6,15 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/gopkg.in/ini.v1"
 )
 
 var (
@@ -19,58 +23,243 @@ var (
 )
 
 type Repository struct {
-	Name       string
-	URL        string
-	Ignore     bool
-	Dir        string
-	LastUpdate time.Time
+	Name         string
+	URL          string
+	Ignore       bool
+	Update       bool
+	Virtual      bool
+	KeyIds       []string
+	Mirrorlist   string
+	WithDir      string
+	MediaInfoDir string
+	XmlInfo      string
+	Priority     int
+	Options      map[string]string // All options of the media as is
+	Dir          string
+	LastUpdate   time.Time
+}
+
+// NewRepository fills a Repository from the urpmi.cfg media block.
+func NewRepository(b *CfgBlock) Repository {
+	rep := Repository{
+		Name:    b.Name,
+		URL:     b.URL,
+		Options: map[string]string{},
+	}
+
+	for _, o := range b.Options() {
+		key, value := o[0], o[1]
+		rep.Options[key] = value
+
+		switch key {
+		case "ignore":
+			rep.Ignore = true
+		case "update":
+			rep.Update = true
+		case "virtual":
+			rep.Virtual = true
+		case "key-ids", "key_ids":
+			rep.KeyIds = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+		case "mirrorlist":
+			rep.Mirrorlist = value
+		case "with-dir":
+			rep.WithDir = value
+		case "media_info_dir":
+			rep.MediaInfoDir = value
+		case "xml-info":
+			rep.XmlInfo = value
+		case "priority":
+			rep.Priority, _ = strconv.Atoi(value)
+		}
+	}
+
+	rep.Dir = VarDir + "/" + rep.Name
+	if !rep.Ignore {
+		if stat, err := os.Lstat(rep.Dir + "/MD5SUM"); err == nil {
+			rep.LastUpdate = stat.ModTime()
+		}
+	}
+
+	return rep
 }
 
 func GetRepositories() (res []Repository, err error) {
-	f, err := os.Open(EtcDir + "/urpmi.cfg")
+	cfg, err := ReadUrpmiCfg(EtcDir + "/urpmi.cfg")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range cfg.Media() {
+		res = append(res, NewRepository(b))
+	}
+
+	return res, nil
+}
+
+// UrpmiCfgFile returns the path of urpmi.cfg.
+func UrpmiCfgFile() string {
+	return EtcDir + "/urpmi.cfg"
+}
+
+// ReadUrpmiCfgForUpdate reads urpmi.cfg for editing, a missing file is
+// treated as an empty configuration.
+func ReadUrpmiCfgForUpdate() (*UrpmiCfg, error) {
+	cfg, err := ReadUrpmiCfg(UrpmiCfgFile())
+	if os.IsNotExist(err) {
+		return &UrpmiCfg{}, nil
+	}
+	return cfg, err
+}
+
+// validMediaName reports whether the name can be used as the name of the
+// media directory in VarDir.
+func validMediaName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// MediaDir returns the directory of the media metadata. It fails if the
+// directory is not inside VarDir, so a bad name in urpmi.cfg or media.cfg
+// can't make zrpm write or remove files outside of it.
+func MediaDir(name string) (string, error) {
+	base := filepath.Clean(VarDir)
+	dir := filepath.Join(base, name)
+	if !validMediaName(name) || filepath.Dir(dir) != base {
+		return "", fmt.Errorf("Incorrect media name '%s'", name)
+	}
+	return dir, nil
+}
+
+// AddRepository adds a new media to the configuration.
+func AddRepository(cfg *UrpmiCfg, name, url string, update bool) error {
+	if !validMediaName(name) {
+		return fmt.Errorf("Incorrect media name '%s'", name)
+	}
+
+	if cfg.FindMedia(name) != nil {
+		return fmt.Errorf("Media '%s' already exists", name)
+	}
+
+	b := cfg.AddMedia(name, url)
+	b.SetFlag("update", update)
+	return nil
+}
+
+// RemoveRepository removes the media from the configuration.
+func RemoveRepository(cfg *UrpmiCfg, name string) error {
+	if !cfg.RemoveMedia(name) {
+		return fmt.Errorf("Media '%s' not found", name)
+	}
+	return nil
+}
+
+// EnableRepository removes or adds the "ignore" flag of the media.
+func EnableRepository(cfg *UrpmiCfg, name string, enable bool) error {
+	b := cfg.FindMedia(name)
+	if b == nil {
+		return fmt.Errorf("Media '%s' not found", name)
+	}
+
+	b.SetFlag("ignore", !enable)
+	return nil
+}
+
+// RenameRepository changes the name of the media.
+func RenameRepository(cfg *UrpmiCfg, name, newName string) error {
+	b := cfg.FindMedia(name)
+	if b == nil {
+		return fmt.Errorf("Media '%s' not found", name)
+	}
+
+	if !validMediaName(newName) {
+		return fmt.Errorf("Incorrect media name '%s'", newName)
+	}
+
+	if cfg.FindMedia(newName) != nil {
+		return fmt.Errorf("Media '%s' already exists", newName)
+	}
+
+	b.SetName(newName)
+	return nil
+}
+
+// RenameMediaDir moves the metadata of the renamed media. The index of the
+// old name is removed, the new one is built on the next load.
+func RenameMediaDir(name, newName string) error {
+	dir, err := MediaDir(name)
 	if err != nil {
-		return
+		return err
+	}
+
+	newDir, err := MediaDir(newName)
+	if err != nil {
+		return err
+	}
+
+	removeMediaIndex(name)
+
+	if fileExists(dir) {
+		return os.Rename(dir, newDir)
 	}
-	defer f.Close()
+	return nil
+}
+
+// DistribMedia is a media listed in the media.cfg of a distribution tree.
+type DistribMedia struct {
+	Name   string
+	URL    string
+	Update bool
+	NoAuto bool // Not added by default: noauto, debug and source media
+}
 
-	r := bufio.NewReader(f)
+// ReadDistribMedia reads media/media_info/media.cfg of the distribution
+// tree at url.
+func ReadDistribMedia(url string) ([]DistribMedia, error) {
+	url = strings.TrimRight(url, "/")
 
-	var head, body string
-	var ferr error
-	for ferr == nil {
+	r, err := OpenURL(url + "/media/media_info/media.cfg")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s: %v", url+"/media/media_info/media.cfg", err)
+	}
 
-		head, ferr = r.ReadString('{')
-		head = strings.Trim(head, " \t\r\n{")
+	res, err := ParseMediaCfg(data, url)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse %s: %v", url+"/media/media_info/media.cfg", err)
+	}
+	return res, nil
+}
 
-		body, ferr = r.ReadString('}')
-		body = strings.Trim(body, " \t\r\n}")
+// ParseMediaCfg parses media.cfg, the media URLs are relative to url.
+func ParseMediaCfg(data []byte, url string) ([]DistribMedia, error) {
+	f, err := ini.Load(data)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(head) == 0 {
+	res := []DistribMedia{}
+	for _, s := range f.Sections() {
+		if s.Name() == ini.DEFAULT_SECTION || s.Name() == "media_info" {
 			continue
 		}
 
-		n := strings.LastIndexAny(head, " \t")
-		if n < 0 {
-			err = fmt.Errorf("incorrect media line '%s' in urpmi.cfg", head)
-			return
+		m := DistribMedia{
+			Name: s.Name(),
+			URL:  url + "/media/" + strings.Trim(s.Name(), "/"),
 		}
 
-		rep := Repository{}
-		rep.Name = strings.Replace(head[:n], "\\", "", -1)
-		rep.URL = head[n:]
-		rep.Ignore = strings.Contains(body, "ignore")
-
-		rep.Dir = VarDir + "/" + rep.Name
-		if !rep.Ignore {
-			//rep.Md5, err = readMd5(rep.Dir + "/MD5SUM")
-
-			if stat, err := os.Lstat(rep.Dir + "/MD5SUM"); err == nil {
-				rep.LastUpdate = stat.ModTime()
-			}
+		if s.HasKey("name") {
+			m.Name = s.Key("name").String()
 		}
-		res = append(res, rep)
+
+		m.Update = s.HasKey("updates_for")
+		m.NoAuto = s.Key("noauto").MustBool(false) || s.HasKey("debug_for") || s.HasKey("source_for")
+		res = append(res, m)
 	}
 
-	err = nil
-	return
+	return res, nil
 }
@@ -0,0 +1,164 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMediaCfg = `[media_info]
+mediacfg_version=2
+version=2014.1
+arch=x86_64
+
+[main/release]
+hdlist=hdlist_main.cz
+name=Main Release
+
+[main/updates]
+hdlist=hdlist_main_updates.cz
+name=Main Updates
+updates_for=main/release
+
+[debug_main/release]
+name=Debug Main Release
+debug_for=main/release
+
+[contrib/release]
+name=Contrib Release
+noauto=1
+`
+
+func TestReadDistribMedia(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rosa/media/media_info/media.cfg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testMediaCfg))
+	}))
+	defer srv.Close()
+
+	res, err := ReadDistribMedia(srv.URL + "/rosa/")
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, srv.URL)
+	}
+
+	expect := []DistribMedia{
+		{"Main Release", srv.URL + "/rosa/media/main/release", false, false},
+		{"Main Updates", srv.URL + "/rosa/media/main/updates", true, false},
+		{"Debug Main Release", srv.URL + "/rosa/media/debug_main/release", false, true},
+		{"Contrib Release", srv.URL + "/rosa/media/contrib/release", false, true},
+	}
+
+	if !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, srv.URL, expect, res)
+	}
+}
+
+func TestEditRepositories(t *testing.T) {
+	cfg, err := ParseUrpmiCfg(strings.NewReader("# Local media\nMain http://example.com/main {\n  update\n}\n"))
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "urpmi.cfg")
+	}
+
+	steps := []struct {
+		name string
+		fn   func() error
+		ok   bool
+	}{
+		{"add", func() error { return AddRepository(cfg, "Contrib Release", "http://example.com/contrib", false) }, true},
+		{"add existing", func() error { return AddRepository(cfg, "Main", "http://example.com/main", false) }, false},
+		{"add bad name", func() error { return AddRepository(cfg, "a/b", "http://example.com/main", false) }, false},
+		{"add dot", func() error { return AddRepository(cfg, ".", "http://example.com/main", false) }, false},
+		{"add dot dot", func() error { return AddRepository(cfg, "..", "http://example.com/main", false) }, false},
+		{"rename to dot dot", func() error { return RenameRepository(cfg, "Main", "..") }, false},
+		{"disable", func() error { return EnableRepository(cfg, "Main", false) }, true},
+		{"rename", func() error { return RenameRepository(cfg, "Main", "Main Release") }, true},
+		{"rename to existing", func() error { return RenameRepository(cfg, "Main Release", "Contrib Release") }, false},
+		{"enable missing", func() error { return EnableRepository(cfg, "Main", true) }, false},
+		{"add", func() error { return AddRepository(cfg, "Tmp", "file:///tmp", true) }, true},
+		{"remove", func() error { return RemoveRepository(cfg, "Tmp") }, true},
+		{"remove missing", func() error { return RemoveRepository(cfg, "Tmp") }, false},
+	}
+
+	for _, s := range steps {
+		if err := s.fn(); (err == nil) != s.ok {
+			t.Errorf(TMPL_MISMATCH, s.name, s.ok, err)
+		}
+	}
+
+	expect := "# Local media\nMain\\ Release http://example.com/main {\n  update\n  ignore\n}\n\n" +
+		"Contrib\\ Release http://example.com/contrib {\n}\n"
+
+	buf := &bytes.Buffer{}
+	cfg.Write(buf)
+	if buf.String() != expect {
+		t.Errorf(TMPL_MISMATCH, "urpmi.cfg", expect, buf.String())
+	}
+}
+
+func TestMediaDir(t *testing.T) {
+	VarDir = "/var/lib/urpmi/"
+
+	cases := []struct {
+		name   string
+		expect string
+	}{
+		{"Main", "/var/lib/urpmi/Main"},
+		{"Main Release", "/var/lib/urpmi/Main Release"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"../etc", ""},
+		{"a/b", ""},
+	}
+
+	for _, c := range cases {
+		res, err := MediaDir(c.name)
+		if (err == nil) != (c.expect != "") || res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.name, c.expect, res)
+		}
+	}
+}
+
+func TestRenameMediaDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	VarDir = dir + "/var"
+	IndexCacheDir = dir + "/cache"
+	os.MkdirAll(VarDir+"/Main", 0755)
+	os.MkdirAll(IndexCacheDir, 0755)
+	ioutil.WriteFile(VarDir+"/Main/MD5SUM", []byte(""), 0644)
+	ioutil.WriteFile(mediaIndexFile("Main", synthesisLayer), []byte("index"), 0644)
+
+	if err := RenameMediaDir("Main", "Main Release"); err != nil {
+		t.Fatalf(TMPL_ERROR, err, "Main")
+	}
+
+	if !fileExists(VarDir + "/Main Release/MD5SUM") {
+		t.Errorf("Media directory was not renamed")
+	}
+
+	if fileExists(mediaIndexFile("Main", synthesisLayer)) {
+		t.Errorf("Index of the old name must be removed")
+	}
+
+	if err := RenameMediaDir("Main Release", ".."); err == nil {
+		t.Errorf("Rename to .. must fail")
+	}
+}
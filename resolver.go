@@ -0,0 +1,548 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Transaction is a result of dependency resolution.
+type Transaction struct {
+	Install  Packages           // new packages
+	Upgrade  Packages           // new versions of installed packages
+	Replaces map[string]Package // newest upgraded instances, keyed by pkgKey
+	Erase    Packages           // installed packages which will be removed
+	Reasons  map[string]string  // why a package is erased, keyed by instanceKey
+	Problems []string           // unresolved dependencies and conflicts
+}
+
+// Resolver computes an install/upgrade/erase set on top of the Cache.
+//
+// The resolver keeps a "result set": the installed packages with the
+// planned changes applied. Every package added to the result set is put
+// into a queue and its obsoletes, conflicts and requires are checked.
+// Several versions of one name.arch may be installed (e.g. kernels), so
+// the result set is keyed by instanceKey. Like urpmi, an upgrade replaces
+// only the newest of them, the older instances are kept.
+type Resolver struct {
+	available map[string]Package   // latest version per name.arch
+	providers map[string][]Package // available packages by provide name
+
+	installed map[string][]Package // installed instances per name.arch
+	install   map[string]Package   // keyed by pkgKey
+	erase     map[string]Package   // keyed by instanceKey
+	reasons   map[string]string    // keyed by instanceKey
+	problems  []string
+
+	result    map[string]Package // keyed by instanceKey
+	provIndex map[string]map[string]bool
+	queue     []Package
+}
+
+func pkgKey(pkg Package) string {
+	return pkg.Name + "." + pkg.Arch
+}
+
+// instanceKey identifies a single installed instance of the package.
+func instanceKey(pkg Package) string {
+	return pkg.Name + "-" + pkg.FullVersion() + "." + pkg.Arch
+}
+
+func NewResolver(cache *Cache, arch []string) *Resolver {
+	r := &Resolver{
+		available: map[string]Package{},
+		providers: map[string][]Package{},
+		installed: map[string][]Package{},
+		install:   map[string]Package{},
+		erase:     map[string]Package{},
+		reasons:   map[string]string{},
+		result:    map[string]Package{},
+		provIndex: map[string]map[string]bool{},
+	}
+
+	for _, pkg := range cache.packages {
+		if !compareArch(arch, pkg) {
+			continue
+		}
+
+		key := pkgKey(pkg)
+		if old, ok := r.available[key]; !ok || pkg.CompareVersion(old) > 0 {
+			r.available[key] = pkg
+		}
+	}
+
+	for _, pkg := range r.available {
+		r.providers[pkg.Name] = append(r.providers[pkg.Name], pkg)
+		for _, p := range pkg.Provides {
+			if p.Name != pkg.Name {
+				r.providers[p.Name] = append(r.providers[p.Name], pkg)
+			}
+		}
+	}
+
+	for _, pkg := range cache.Installed() {
+		key := pkgKey(pkg)
+		r.installed[key] = append(r.installed[key], pkg)
+		r.addToResult(pkg)
+	}
+
+	return r
+}
+
+// newestInstalled returns the latest installed instance of name.arch.
+func (r *Resolver) newestInstalled(key string) (Package, bool) {
+	var res Package
+	for i, pkg := range r.installed[key] {
+		if i == 0 || pkg.CompareVersion(res) > 0 {
+			res = pkg
+		}
+	}
+	return res, len(r.installed[key]) > 0
+}
+
+func (r *Resolver) addToResult(pkg Package) {
+	key := instanceKey(pkg)
+	r.result[key] = pkg
+
+	names := []string{pkg.Name}
+	for _, p := range pkg.Provides {
+		names = append(names, p.Name)
+	}
+
+	for _, n := range names {
+		if r.provIndex[n] == nil {
+			r.provIndex[n] = map[string]bool{}
+		}
+		r.provIndex[n][key] = true
+	}
+}
+
+func (r *Resolver) removeFromResult(pkg Package) {
+	key := instanceKey(pkg)
+	delete(r.result, key)
+
+	delete(r.provIndex[pkg.Name], key)
+	for _, p := range pkg.Provides {
+		delete(r.provIndex[p.Name], key)
+	}
+}
+
+// providedBy returns packages from the result set which provide dep.
+func (r *Resolver) providedBy(dep Dependency) []Package {
+	res := []Package{}
+	for key := range r.provIndex[dep.Name] {
+		pkg := r.result[key]
+		if pkg.ProvidesDep(dep) {
+			res = append(res, pkg)
+		}
+	}
+
+	sort.Sort(Packages(res))
+	return res
+}
+
+func (r *Resolver) isSatisfied(dep Dependency) bool {
+	if strings.HasPrefix(dep.Name, "rpmlib(") {
+		return true
+	}
+
+	return len(r.providedBy(dep)) > 0
+}
+
+// Install marks the latest available versions of the packages for
+// installation.
+func (r *Resolver) Install(names ...string) error {
+	for _, name := range names {
+		candidates := []Package{}
+		for _, pkg := range r.available {
+			if pkg.Name == name {
+				candidates = append(candidates, pkg)
+			}
+		}
+
+		if len(candidates) == 0 {
+			return fmt.Errorf("no package named %s", name)
+		}
+
+		r.InstallOneOf(Dependency{Name: name}, candidates)
+	}
+
+	return nil
+}
+
+// InstallOneOf marks the preferred of the candidates for the dependency
+// for installation, the same way a provider of a requirement is chosen.
+// It is used for the command line arguments which aren't plain names,
+// like "name.arch" or a capability.
+func (r *Resolver) InstallOneOf(dep Dependency, candidates []Package) {
+	if len(candidates) == 0 {
+		return
+	}
+
+	sort.Sort(r.byPreference(candidates, dep, ""))
+	r.add(candidates[0])
+}
+
+// UpgradeAll marks for installation all packages which upgrade or obsolete
+// installed ones.
+func (r *Resolver) UpgradeAll() {
+	keys := []string{}
+	for key := range r.available {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		pkg := r.available[key]
+
+		if old, ok := r.newestInstalled(key); ok {
+			if pkg.CompareVersion(old) > 0 {
+				r.add(pkg)
+			}
+			continue
+		}
+
+		for _, o := range pkg.Obsoletes {
+			if len(r.obsoletedBy(pkg, o)) > 0 {
+				r.add(pkg)
+				break
+			}
+		}
+	}
+}
+
+// Resolve processes the queue and returns the transaction.
+func (r *Resolver) Resolve() Transaction {
+	for len(r.queue) > 0 {
+		pkg := r.queue[0]
+		r.queue = r.queue[1:]
+
+		if _, ok := r.install[pkgKey(pkg)]; !ok {
+			continue
+		}
+
+		r.processObsoletes(pkg)
+		r.processConflicts(pkg)
+		r.processRequires(pkg)
+	}
+
+	t := Transaction{
+		Replaces: map[string]Package{},
+		Reasons:  r.reasons,
+		Problems: r.problems,
+	}
+
+	for key, pkg := range r.install {
+		if old, ok := r.newestInstalled(key); ok {
+			t.Replaces[key] = old
+			t.Upgrade = append(t.Upgrade, pkg)
+		} else {
+			t.Install = append(t.Install, pkg)
+		}
+	}
+
+	// Several instances of one name are erased in a stable order
+	keys := []string{}
+	for key := range r.erase {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t.Erase = append(t.Erase, r.erase[key])
+	}
+
+	sort.Sort(t.Install)
+	sort.Sort(t.Upgrade)
+	sort.Stable(t.Erase)
+	return t
+}
+
+func (r *Resolver) problem(format string, a ...interface{}) {
+	r.problems = append(r.problems, fmt.Sprintf(format, a...))
+}
+
+// add puts pkg to the result set. The newest installed instance with the
+// same name and arch is replaced if pkg is newer than it, older instances
+// and packages of other architectures are kept.
+func (r *Resolver) add(pkg Package) {
+	key := pkgKey(pkg)
+
+	if _, ok := r.install[key]; ok {
+		return
+	}
+
+	if newest, ok := r.newestInstalled(key); ok && newest.CompareVersion(pkg) >= 0 {
+		return
+	}
+
+	r.install[key] = pkg
+
+	// The instance erased before is replaced by the upgrade
+	old, installed := r.newestInstalled(key)
+	replaced := false
+	if installed {
+		_, replaced = r.result[instanceKey(old)]
+		if replaced {
+			r.removeFromResult(old)
+		}
+		delete(r.erase, instanceKey(old))
+		delete(r.reasons, instanceKey(old))
+	}
+
+	r.addToResult(pkg)
+	r.queue = append(r.queue, pkg)
+
+	if replaced {
+		r.checkDependents(old, fmt.Sprintf("upgrade of %s", old.Name))
+	}
+}
+
+// isNew reports whether pkg is the package marked for installation and not
+// an installed instance with the same name and arch.
+func (r *Resolver) isNew(pkg Package) bool {
+	p, ok := r.install[pkgKey(pkg)]
+	return ok && instanceKey(p) == instanceKey(pkg)
+}
+
+// remove erases the package instance from the result set.
+func (r *Resolver) remove(pkg Package, reason string) {
+	key := instanceKey(pkg)
+
+	if _, ok := r.result[key]; !ok {
+		return
+	}
+
+	if r.isNew(pkg) {
+		r.problem("%s-%s.%s can't be installed: %s", pkg.Name, pkg.Version, pkg.Arch, reason)
+		return
+	}
+
+	r.removeFromResult(pkg)
+	r.erase[key] = pkg
+	r.reasons[key] = reason
+
+	r.checkDependents(pkg, fmt.Sprintf("removal of %s", pkg.Name))
+}
+
+// checkDependents upgrades or erases the installed packages which required
+// something from pkg that is not provided by the result set anymore.
+func (r *Resolver) checkDependents(pkg Package, reason string) {
+	keys := []string{}
+	for key := range r.result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		dep, ok := r.result[key]
+		if !ok {
+			continue
+		}
+
+		if r.isNew(dep) {
+			continue
+		}
+
+		for _, req := range dep.Requires {
+			if !pkg.ProvidesDep(req) || r.isSatisfied(req) {
+				continue
+			}
+
+			if newer, ok := r.upgradeFor(dep); ok {
+				r.add(newer)
+			} else {
+				r.remove(dep, fmt.Sprintf("requires %s, broken by %s", req, reason))
+			}
+			break
+		}
+	}
+}
+
+// obsoletedBy returns packages from the result set obsoleted by o.
+func (r *Resolver) obsoletedBy(pkg Package, o Dependency) []Package {
+	res := []Package{}
+	for key := range r.provIndex[o.Name] {
+		q := r.result[key]
+		if q.Name != o.Name || q.Name == pkg.Name {
+			continue
+		}
+
+		if (Dependency{Name: q.Name, Flags: "==", EVR: q.FullVersion()}).Overlaps(o) {
+			res = append(res, q)
+		}
+	}
+
+	sort.Sort(Packages(res))
+	return res
+}
+
+func (r *Resolver) processObsoletes(pkg Package) {
+	for _, o := range pkg.Obsoletes {
+		for _, q := range r.obsoletedBy(pkg, o) {
+			r.remove(q, fmt.Sprintf("obsoleted by %s-%s", pkg.Name, pkg.FullVersion()))
+		}
+	}
+}
+
+func (r *Resolver) processConflicts(pkg Package) {
+	for _, c := range pkg.Conflicts {
+		for _, q := range r.providedBy(c) {
+			if q.Name != pkg.Name {
+				r.resolveConflict(q, pkg)
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range r.result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		q, ok := r.result[key]
+		if !ok || q.Name == pkg.Name {
+			continue
+		}
+
+		for _, c := range q.Conflicts {
+			if pkg.ProvidesDep(c) {
+				r.resolveConflict(q, pkg)
+				break
+			}
+		}
+	}
+}
+
+// upgradeFor returns the available version which replaces the installed
+// package. Only the newest installed instance is upgraded and only once.
+func (r *Resolver) upgradeFor(pkg Package) (Package, bool) {
+	key := pkgKey(pkg)
+	newer, ok := r.available[key]
+	if !ok {
+		return newer, false
+	}
+
+	if _, ok := r.install[key]; ok {
+		return newer, false
+	}
+
+	newest, ok := r.newestInstalled(key)
+	if !ok || instanceKey(newest) != instanceKey(pkg) {
+		return newer, false
+	}
+	return newer, newer.CompareVersion(pkg) > 0
+}
+
+// resolveConflict tries to upgrade the installed package q to a version
+// which doesn't conflict with pkg, otherwise q is erased.
+func (r *Resolver) resolveConflict(q Package, pkg Package) {
+	if r.isNew(q) {
+		r.problem("%s-%s.%s conflicts with %s-%s.%s", pkg.Name, pkg.Version, pkg.Arch, q.Name, q.Version, q.Arch)
+		return
+	}
+
+	if newer, ok := r.upgradeFor(q); ok && !conflicts(newer, pkg) {
+		r.add(newer)
+		return
+	}
+
+	r.remove(q, fmt.Sprintf("conflicts with %s-%s", pkg.Name, pkg.FullVersion()))
+}
+
+func conflicts(p1, p2 Package) bool {
+	for _, c := range p1.Conflicts {
+		if p2.ProvidesDep(c) {
+			return true
+		}
+	}
+
+	for _, c := range p2.Conflicts {
+		if p1.ProvidesDep(c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Resolver) processRequires(pkg Package) {
+	for _, req := range pkg.Requires {
+		if r.isSatisfied(req) {
+			continue
+		}
+
+		candidates := []Package{}
+		for _, p := range r.providers[req.Name] {
+			if p.ProvidesDep(req) {
+				candidates = append(candidates, p)
+			}
+		}
+
+		if len(candidates) == 0 {
+			r.problem("nothing provides %s needed by %s-%s.%s", req, pkg.Name, pkg.Version, pkg.Arch)
+			continue
+		}
+
+		sort.Sort(r.byPreference(candidates, req, pkg.Arch))
+		r.add(candidates[0])
+	}
+}
+
+// providerPreference sorts candidates for a dependency: a package named as
+// the dependency, then the requiring package's arch, then upgrades of the
+// installed packages, then shorter names, then newer versions.
+type providerPreference struct {
+	pkgs      []Package
+	dep       Dependency
+	arch      string
+	installed map[string][]Package
+}
+
+func (r *Resolver) byPreference(pkgs []Package, dep Dependency, arch string) providerPreference {
+	if arch == "" {
+		arch = machineArch()
+	}
+	return providerPreference{pkgs: pkgs, dep: dep, arch: arch, installed: r.installed}
+}
+
+func (p providerPreference) Len() int {
+	return len(p.pkgs)
+}
+
+func (p providerPreference) Swap(i, j int) {
+	p.pkgs[i], p.pkgs[j] = p.pkgs[j], p.pkgs[i]
+}
+
+func (p providerPreference) Less(i, j int) bool {
+	a, b := p.pkgs[i], p.pkgs[j]
+
+	if (a.Name == p.dep.Name) != (b.Name == p.dep.Name) {
+		return a.Name == p.dep.Name
+	}
+
+	if (a.Arch == p.arch) != (b.Arch == p.arch) {
+		return a.Arch == p.arch
+	}
+
+	aInst := len(p.installed[pkgKey(a)]) > 0
+	bInst := len(p.installed[pkgKey(b)]) > 0
+	if aInst != bInst {
+		return aInst
+	}
+
+	if len(a.Name) != len(b.Name) {
+		return len(a.Name) < len(b.Name)
+	}
+
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+
+	return a.CompareVersion(b) > 0
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func resolverTestCache() *Cache {
+	c := &Cache{installed: map[string][]NEVRA{}}
+	c.packages = Packages{
+		{Name: "app", Version: "1.0-1", Arch: "x86_64",
+			Requires: []Dependency{{Name: "libfoo.so.1()(64bit)"}, {Name: "app-data", Flags: ">=", EVR: "1.0"}}},
+		{Name: "app-data", Version: "1.0-1", Arch: "noarch"},
+		{Name: "lib64foo1", Version: "1.0-1", Arch: "x86_64",
+			Provides: []Dependency{{Name: "libfoo.so.1()(64bit)"}}},
+		{Name: "libfoo1", Version: "1.0-1", Arch: "i586",
+			Provides: []Dependency{{Name: "libfoo.so.1"}}},
+
+		{Name: "broken", Version: "1.0-1", Arch: "x86_64",
+			Requires: []Dependency{{Name: "missing"}}},
+
+		{Name: "newtool", Version: "2.0-1", Arch: "x86_64",
+			Obsoletes: []Dependency{{Name: "oldtool", Flags: "<", EVR: "2.0"}}},
+		{Name: "oldtool", Version: "1.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "x86_64"}}},
+		{Name: "oldtool-plugins", Version: "1.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "x86_64"}},
+			Requires:  []Dependency{{Name: "oldtool"}}},
+
+		{Name: "daemon", Version: "1.0-1", Arch: "x86_64",
+			Conflicts: []Dependency{{Name: "otherdaemon"}}},
+		{Name: "otherdaemon", Version: "1.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "x86_64"}}},
+
+		{Name: "wine", Version: "1.0-1", Arch: "i586",
+			Installed: []NEVRA{{Version: "1.0-1", Arch: "i586"}}},
+		{Name: "wine", Version: "1.0-1", Arch: "x86_64"},
+		{Name: "wine", Version: "1.1-1", Arch: "x86_64"},
+
+		{Name: "kernel", Version: "2.0-1", Arch: "x86_64",
+			Installed: []NEVRA{{Version: "2.0-1", Arch: "x86_64"}, {Version: "1.0-1", Arch: "x86_64"}}},
+		{Name: "kmod", Version: "1.0-1", Arch: "x86_64",
+			Conflicts: []Dependency{{Name: "kernel", Flags: "<", EVR: "2.0"}}},
+	}
+
+	sort.Sort(c.packages)
+	for i, pkg := range c.packages {
+		for _, n := range pkg.Installed {
+			n.Name = pkg.Name
+			c.installed[pkg.Name] = append(c.installed[pkg.Name], n)
+
+			inst := pkg
+			inst.Epoch = n.Epoch
+			inst.Version = n.Version
+			inst.Arch = n.Arch
+			inst.Installed = nil
+			c.installedPkgs = append(c.installedPkgs, inst)
+		}
+		c.packages[i].Installed = nil
+	}
+
+	for i, pkg := range c.packages {
+		c.packages[i].Installed = c.installed[pkg.Name]
+	}
+	return c
+}
+
+func transactionNames(pkgs Packages) []string {
+	res := []string{}
+	for _, p := range pkgs {
+		res = append(res, p.Name+"-"+p.Version+"."+p.Arch)
+	}
+	return res
+}
+
+func TestResolverInstall(t *testing.T) {
+	cases := []struct {
+		names    []string
+		install  []string
+		upgrade  []string
+		erase    []string
+		problems int
+	}{
+		{
+			[]string{"app"},
+			[]string{"app-1.0-1.x86_64", "app-data-1.0-1.noarch", "lib64foo1-1.0-1.x86_64"},
+			[]string{},
+			[]string{},
+			0,
+		},
+
+		{
+			[]string{"broken"},
+			[]string{"broken-1.0-1.x86_64"},
+			[]string{},
+			[]string{},
+			1,
+		},
+
+		{
+			[]string{"newtool"},
+			[]string{"newtool-2.0-1.x86_64"},
+			[]string{},
+			[]string{"oldtool-1.0-1.x86_64", "oldtool-plugins-1.0-1.x86_64"},
+			0,
+		},
+
+		{
+			[]string{"daemon"},
+			[]string{"daemon-1.0-1.x86_64"},
+			[]string{},
+			[]string{"otherdaemon-1.0-1.x86_64"},
+			0,
+		},
+
+		// Only the conflicting kernel is erased
+		{
+			[]string{"kmod"},
+			[]string{"kmod-1.0-1.x86_64"},
+			[]string{},
+			[]string{"kernel-1.0-1.x86_64"},
+			0,
+		},
+	}
+
+	arch := []string{"x86_64", "noarch"}
+	for _, c := range cases {
+		r := NewResolver(resolverTestCache(), arch)
+		if err := r.Install(c.names...); err != nil {
+			t.Errorf(TMPL_ERROR, err, c.names)
+			continue
+		}
+
+		tr := r.Resolve()
+
+		if res := transactionNames(tr.Install); !reflect.DeepEqual(res, c.install) {
+			t.Errorf(TMPL_MISMATCH, c.names, c.install, res)
+		}
+
+		if res := transactionNames(tr.Upgrade); !reflect.DeepEqual(res, c.upgrade) {
+			t.Errorf(TMPL_MISMATCH, c.names, c.upgrade, res)
+		}
+
+		if res := transactionNames(tr.Erase); !reflect.DeepEqual(res, c.erase) {
+			t.Errorf(TMPL_MISMATCH, c.names, c.erase, res)
+		}
+
+		if len(tr.Problems) != c.problems {
+			t.Errorf(TMPL_MISMATCH, c.names, c.problems, tr.Problems)
+		}
+	}
+}
+
+func TestResolverInstallOneOf(t *testing.T) {
+	cases := []struct {
+		name    string
+		install []string
+	}{
+		{"libfoo1.i586", []string{"libfoo1-1.0-1.i586"}},
+		{"libfoo.so.1()(64bit)", []string{"lib64foo1-1.0-1.x86_64"}},
+		{"wine.x86_64", []string{"wine-1.1-1.x86_64"}},
+	}
+
+	arch := []string{"x86_64", "i586", "noarch"}
+	for _, c := range cases {
+		cache := resolverTestCache()
+		r := NewResolver(cache, arch)
+		r.InstallOneOf(Dependency{Name: c.name}, installCandidates(cache, c.name, arch))
+
+		if res := transactionNames(r.Resolve().Install); !reflect.DeepEqual(res, c.install) {
+			t.Errorf(TMPL_MISMATCH, c.name, c.install, res)
+		}
+	}
+}
+
+func TestResolverUpgradeAll(t *testing.T) {
+	r := NewResolver(resolverTestCache(), []string{"x86_64", "i586", "noarch"})
+	r.UpgradeAll()
+	tr := r.Resolve()
+
+	// The i586 wine is kept, newtool replaces oldtool through obsoletes.
+	// The latest kernel is already installed.
+	expect := []string{"newtool-2.0-1.x86_64"}
+	if res := transactionNames(tr.Install); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "install", expect, res)
+	}
+
+	expect = []string{"oldtool-1.0-1.x86_64", "oldtool-plugins-1.0-1.x86_64"}
+	if res := transactionNames(tr.Erase); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "erase", expect, res)
+	}
+
+	if len(tr.Upgrade) != 0 {
+		t.Errorf(TMPL_MISMATCH, "upgrade", []string{}, transactionNames(tr.Upgrade))
+	}
+}
+
+func TestResolverUpgradeInstances(t *testing.T) {
+	cache := resolverTestCache()
+	cache.packages = append(cache.packages, Package{Name: "kernel", Version: "3.0-1", Arch: "x86_64", Installed: cache.installed["kernel"]})
+
+	r := NewResolver(cache, []string{"x86_64", "noarch"})
+	r.UpgradeAll()
+	tr := r.Resolve()
+
+	// Only the newest kernel is replaced, the older one is kept
+	expect := []string{"kernel-3.0-1.x86_64"}
+	if res := transactionNames(tr.Upgrade); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "upgrade", expect, res)
+	}
+
+	if res := tr.Replaces["kernel.x86_64"].Version; res != "2.0-1" {
+		t.Errorf(TMPL_MISMATCH, "replaces", "2.0-1", res)
+	}
+
+	for _, pkg := range tr.Erase {
+		if pkg.Name == "kernel" {
+			t.Errorf("%s-%s must not be erased", pkg.Name, pkg.Version)
+		}
+	}
+
+	if _, ok := r.result["kernel-1.0-1.x86_64"]; !ok {
+		t.Errorf("kernel-1.0-1.x86_64 must be kept")
+	}
+}
@@ -0,0 +1,729 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	RpmDbDir = "/var/lib/rpm"
+)
+
+// ReadRpmDb reads headers of all installed packages from the rpm database
+// in dir. Both the sqlite (rpmdb.sqlite) and the legacy Berkeley DB
+// (Packages) formats are supported. A missing database means that nothing
+// is installed.
+func ReadRpmDb(dir string) ([]*RPMHeader, error) {
+	headers, err := readRpmDbHeaders(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*RPMHeader, 0, len(headers))
+	for _, h := range headers {
+		// Public keys are stored as packages
+		if h.String(RPMTAG_NAME) == "gpg-pubkey" {
+			continue
+		}
+
+		res = append(res, h)
+	}
+
+	return res, nil
+}
+
+// ReadRpmDbPubkeys returns the gpg-pubkey entries imported into the rpm
+// database in dir.
+func ReadRpmDbPubkeys(dir string) ([]*RPMHeader, error) {
+	headers, err := readRpmDbHeaders(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*RPMHeader{}
+	for _, h := range headers {
+		if h.String(RPMTAG_NAME) == "gpg-pubkey" {
+			res = append(res, h)
+		}
+	}
+
+	return res, nil
+}
+
+func readRpmDbHeaders(dir string) ([]*RPMHeader, error) {
+	var blobs [][]byte
+	var err error
+
+	switch {
+	case fileExists(dir + "/rpmdb.sqlite"):
+		blobs, err = readSqliteRpmDb(dir + "/rpmdb.sqlite")
+
+	case fileExists(dir + "/Packages"):
+		blobs, err = readBdbRpmDb(dir + "/Packages")
+
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*RPMHeader, 0, len(blobs))
+	for _, blob := range blobs {
+		h, err := ParseRPMHeaderBlob(blob)
+		if err != nil {
+			return nil, fmt.Errorf("Can't parse rpm database header: %v", err)
+		}
+
+		res = append(res, h)
+	}
+
+	return res, nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// ****************************************************************
+// SQLite
+
+// sqliteDb is a minimal read-only reader for SQLite 3 database files.
+// It can only walk table b-trees, which is enough for the rpm
+// "Packages" table.
+type sqliteDb struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+func readSqliteRpmDb(file string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// rpm keeps the database in WAL mode, the last transactions are only
+	// in the log until sqlite checkpoints it.
+	wal, err := ioutil.ReadFile(file + "-wal")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(wal) > 0 {
+		data, err = applySqliteWal(data, wal)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read %s: %v", file+"-wal", err)
+		}
+	}
+
+	db, err := newSqliteDb(data)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s: %v", file, err)
+	}
+
+	root, err := db.tableRoot("Packages")
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s: %v", file, err)
+	}
+
+	res := [][]byte{}
+	err = db.walkTable(root, func(rowid int64, record []byte) error {
+		values, err := sqliteRecord(record)
+		if err != nil {
+			return err
+		}
+
+		// CREATE TABLE Packages (hnum INTEGER PRIMARY KEY, blob BLOB NOT NULL)
+		if len(values) < 2 {
+			return fmt.Errorf("incorrect Packages record %v", rowid)
+		}
+
+		if blob, ok := values[1].([]byte); ok {
+			res = append(res, blob)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s: %v", file, err)
+	}
+
+	return res, nil
+}
+
+func newSqliteDb(data []byte) (*sqliteDb, error) {
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		return nil, fmt.Errorf("not a SQLite 3 database")
+	}
+
+	db := &sqliteDb{data: data}
+	db.pageSize = int(binary.BigEndian.Uint16(data[16:18]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usable = db.pageSize - int(data[20])
+
+	if db.pageSize < 512 || db.usable < 480 {
+		return nil, fmt.Errorf("incorrect page size %v", db.pageSize)
+	}
+
+	return db, nil
+}
+
+// applySqliteWal returns the database with the pages of the committed
+// transactions from the write-ahead log, like sqlite does when it
+// recovers the log. The frames after the last valid commit frame are
+// ignored.
+func applySqliteWal(data []byte, wal []byte) ([]byte, error) {
+	if len(wal) < 32 {
+		return data, nil
+	}
+
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(wal) {
+	case 0x377f0682:
+		order = binary.LittleEndian
+	case 0x377f0683:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a SQLite write-ahead log")
+	}
+
+	pageSize := int(binary.BigEndian.Uint32(wal[8:]))
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("incorrect page size %v", pageSize)
+	}
+
+	// sqlite ignores a log with a broken header
+	s0, s1 := sqliteWalChecksum(order, wal[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(wal[24:]) || s1 != binary.BigEndian.Uint32(wal[28:]) {
+		return data, nil
+	}
+
+	pages := map[int][]byte{}
+	pending := map[int][]byte{}
+	dbPages := 0
+
+	for off := 32; off+24+pageSize <= len(wal); off += 24 + pageSize {
+		frame := wal[off : off+24]
+		page := wal[off+24 : off+24+pageSize]
+
+		// The frames of the previous log generations have other salts
+		if !bytes.Equal(frame[8:16], wal[16:24]) {
+			break
+		}
+
+		s0, s1 = sqliteWalChecksum(order, frame[:8], s0, s1)
+		s0, s1 = sqliteWalChecksum(order, page, s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:]) || s1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+
+		pending[int(binary.BigEndian.Uint32(frame))] = page
+
+		// The commit frame holds the database size in pages
+		if size := int(binary.BigEndian.Uint32(frame[4:])); size > 0 {
+			for n, p := range pending {
+				pages[n] = p
+			}
+			pending = map[int][]byte{}
+			dbPages = size
+		}
+	}
+
+	if dbPages == 0 {
+		return data, nil
+	}
+
+	res := make([]byte, dbPages*pageSize)
+	copy(res, data)
+	for n, p := range pages {
+		if n >= 1 && n <= dbPages {
+			copy(res[(n-1)*pageSize:], p)
+		}
+	}
+
+	return res, nil
+}
+
+func sqliteWalChecksum(order binary.ByteOrder, b []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+func (db *sqliteDb) page(n int) ([]byte, error) {
+	if n < 1 || n*db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("page %v is out of file", n)
+	}
+	return db.data[(n-1)*db.pageSize : n*db.pageSize], nil
+}
+
+// tableRoot finds the root page of the table in sqlite_master.
+func (db *sqliteDb) tableRoot(name string) (int, error) {
+	root := 0
+	err := db.walkTable(1, func(rowid int64, record []byte) error {
+		values, err := sqliteRecord(record)
+		if err != nil {
+			return err
+		}
+
+		// sqlite_master: type, name, tbl_name, rootpage, sql
+		if len(values) < 4 {
+			return nil
+		}
+
+		typ, _ := values[0].(string)
+		tbl, _ := values[1].(string)
+		if typ == "table" && strings.EqualFold(tbl, name) {
+			if n, ok := values[3].(int64); ok {
+				root = int(n)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if root == 0 {
+		return 0, fmt.Errorf("table %s not found", name)
+	}
+
+	return root, nil
+}
+
+// walkTable calls fn for every row of the table b-tree with the given root
+// page.
+func (db *sqliteDb) walkTable(pgno int, fn func(rowid int64, record []byte) error) error {
+	return db.walkTablePage(pgno, fn, 0)
+}
+
+func (db *sqliteDb) walkTablePage(pgno int, fn func(rowid int64, record []byte) error, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("b-tree is too deep")
+	}
+
+	page, err := db.page(pgno)
+	if err != nil {
+		return err
+	}
+
+	hdr := 0
+	if pgno == 1 {
+		hdr = 100
+	}
+
+	if len(page) < hdr+12 {
+		return fmt.Errorf("page %v is too short", pgno)
+	}
+
+	typ := page[hdr]
+	ncells := int(binary.BigEndian.Uint16(page[hdr+3:]))
+	ptrs := hdr + 8
+	if typ == 0x05 {
+		ptrs = hdr + 12
+	}
+
+	if ptrs+ncells*2 > len(page) {
+		return fmt.Errorf("page %v has incorrect cell count", pgno)
+	}
+
+	for i := 0; i < ncells; i++ {
+		off := int(binary.BigEndian.Uint16(page[ptrs+i*2:]))
+		if off >= len(page) {
+			return fmt.Errorf("page %v has incorrect cell offset", pgno)
+		}
+		cell := page[off:]
+
+		switch typ {
+		case 0x05: // interior table page
+			if len(cell) < 4 {
+				return fmt.Errorf("page %v has truncated cell", pgno)
+			}
+			child := int(binary.BigEndian.Uint32(cell))
+			if err := db.walkTablePage(child, fn, depth+1); err != nil {
+				return err
+			}
+
+		case 0x0d: // leaf table page
+			size, n := sqliteVarint(cell)
+			rowid, m := sqliteVarint(cell[n:])
+			record, err := db.payload(cell[n+m:], int(size))
+			if err != nil {
+				return fmt.Errorf("page %v: %v", pgno, err)
+			}
+
+			if err := fn(rowid, record); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("page %v has unexpected type %v", pgno, typ)
+		}
+	}
+
+	if typ == 0x05 {
+		right := int(binary.BigEndian.Uint32(page[hdr+8:]))
+		return db.walkTablePage(right, fn, depth+1)
+	}
+
+	return nil
+}
+
+// payload assembles a cell payload, following the overflow page chain.
+func (db *sqliteDb) payload(cell []byte, size int) ([]byte, error) {
+	// The payload can't be larger than the file
+	if size < 0 || size > len(db.data) {
+		return nil, fmt.Errorf("incorrect payload size %v", size)
+	}
+
+	maxLocal := db.usable - 35
+	if size <= maxLocal {
+		if size > len(cell) {
+			return nil, fmt.Errorf("truncated cell")
+		}
+		return cell[:size], nil
+	}
+
+	minLocal := (db.usable-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(db.usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+
+	if local+4 > len(cell) {
+		return nil, fmt.Errorf("truncated cell")
+	}
+
+	res := make([]byte, 0, size)
+	res = append(res, cell[:local]...)
+	next := int(binary.BigEndian.Uint32(cell[local:]))
+
+	for pages := 0; len(res) < size; pages++ {
+		if pages > len(db.data)/db.pageSize {
+			return nil, fmt.Errorf("overflow chain is looped")
+		}
+
+		page, err := db.page(next)
+		if err != nil {
+			return nil, fmt.Errorf("overflow: %v", err)
+		}
+
+		n := size - len(res)
+		if n > db.usable-4 {
+			n = db.usable - 4
+		}
+
+		res = append(res, page[4:4+n]...)
+		next = int(binary.BigEndian.Uint32(page))
+	}
+
+	return res, nil
+}
+
+func sqliteVarint(b []byte) (int64, int) {
+	var res int64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return res<<8 | int64(b[i]), 9
+		}
+
+		res = res<<7 | int64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return res, i + 1
+		}
+	}
+	return res, len(b)
+}
+
+// sqliteRecord decodes a record into nil, int64, float (as nil), []byte
+// and string values.
+func sqliteRecord(record []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(record)
+	if hdrSize < 0 || int(hdrSize) > len(record) {
+		return nil, fmt.Errorf("incorrect record header")
+	}
+
+	types := []int64{}
+	for n < int(hdrSize) {
+		t, m := sqliteVarint(record[n:])
+		if t < 0 {
+			return nil, fmt.Errorf("incorrect record serial type")
+		}
+		types = append(types, t)
+		n += m
+	}
+
+	res := make([]interface{}, 0, len(types))
+	body := record[hdrSize:]
+	for _, t := range types {
+		size := 0
+		switch {
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t >= 12:
+			size = int(t-12) / 2
+		}
+
+		if size > len(body) {
+			return nil, fmt.Errorf("truncated record")
+		}
+		v := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0 || t == 7:
+			res = append(res, nil)
+
+		case t >= 1 && t <= 6:
+			var i int64
+			if v[0]&0x80 != 0 {
+				i = -1
+			}
+			for _, b := range v {
+				i = i<<8 | int64(b)
+			}
+			res = append(res, i)
+
+		case t == 8:
+			res = append(res, int64(0))
+
+		case t == 9:
+			res = append(res, int64(1))
+
+		case t >= 12 && t%2 == 0:
+			res = append(res, v)
+
+		case t >= 13:
+			res = append(res, string(v))
+
+		default:
+			res = append(res, nil)
+		}
+	}
+
+	return res, nil
+}
+
+// ****************************************************************
+// Berkeley DB
+
+const (
+	bdbHashMagic  = 0x061561
+	bdbBtreeMagic = 0x053162
+
+	bdbPageHeaderSize = 26
+
+	bdbPageHashUnsorted = 2
+	bdbPageLeafBtree    = 5
+	bdbPageOverflow     = 7
+	bdbPageHash         = 13
+
+	bdbKeyData = 1
+	bdbOffPage = 3
+)
+
+// readBdbRpmDb reads values of a Berkeley DB hash or btree database. The
+// pages are scanned sequentially, big values are assembled from their
+// overflow page chains.
+func readBdbRpmDb(file string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 512 {
+		return nil, fmt.Errorf("Can't read %s: file is too short", file)
+	}
+
+	var order binary.ByteOrder
+	magic := binary.LittleEndian.Uint32(data[12:16])
+	switch {
+	case magic == bdbHashMagic || magic == bdbBtreeMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data[12:16]) == bdbHashMagic || binary.BigEndian.Uint32(data[12:16]) == bdbBtreeMagic:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("Can't read %s: not a Berkeley DB hash or btree file", file)
+	}
+
+	pageSize := int(order.Uint32(data[20:24]))
+	if pageSize < 512 || pageSize > 65536 {
+		return nil, fmt.Errorf("Can't read %s: incorrect page size %v", file, pageSize)
+	}
+
+	db := bdbFile{data: data, order: order, pageSize: pageSize}
+
+	res := [][]byte{}
+	for pgno := 1; (pgno+1)*pageSize <= len(data); pgno++ {
+		page := db.page(pgno)
+		typ := page[25]
+		if typ != bdbPageHash && typ != bdbPageHashUnsorted && typ != bdbPageLeafBtree {
+			continue
+		}
+
+		items, err := db.items(page, typ == bdbPageLeafBtree)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read %s: page %v: %v", file, pgno, err)
+		}
+
+		// Items are key/value pairs; rpm stores the header number as a key
+		for i := 0; i+1 < len(items); i += 2 {
+			key := items[i]
+			if bytes.Equal(key, []byte{0, 0, 0, 0}) {
+				continue
+			}
+
+			if len(items[i+1]) < 8 {
+				continue
+			}
+			res = append(res, items[i+1])
+		}
+	}
+
+	return res, nil
+}
+
+type bdbFile struct {
+	data     []byte
+	order    binary.ByteOrder
+	pageSize int
+}
+
+func (db bdbFile) page(n int) []byte {
+	return db.data[n*db.pageSize : (n+1)*db.pageSize]
+}
+
+// items returns all items of a hash or btree leaf page.
+func (db bdbFile) items(page []byte, btree bool) ([][]byte, error) {
+	entries := int(db.order.Uint16(page[20:22]))
+	if bdbPageHeaderSize+entries*2 > len(page) {
+		return nil, fmt.Errorf("incorrect number of entries %v", entries)
+	}
+
+	res := make([][]byte, 0, entries)
+	for i := 0; i < entries; i++ {
+		off := int(db.order.Uint16(page[bdbPageHeaderSize+i*2:]))
+		if off >= len(page) {
+			return nil, fmt.Errorf("incorrect item offset %v", off)
+		}
+
+		var item []byte
+		var err error
+		if btree {
+			item, err = db.btreeItem(page, off)
+		} else {
+			end := len(page)
+			if i > 0 {
+				end = int(db.order.Uint16(page[bdbPageHeaderSize+(i-1)*2:]))
+			}
+			item, err = db.hashItem(page, off, end)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+	}
+
+	return res, nil
+}
+
+func (db bdbFile) hashItem(page []byte, off int, end int) ([]byte, error) {
+	switch page[off] {
+	case bdbKeyData:
+		if end > len(page) || end < off+1 {
+			return nil, fmt.Errorf("incorrect item at %v", off)
+		}
+		return page[off+1 : end], nil
+
+	case bdbOffPage:
+		if off+12 > len(page) {
+			return nil, fmt.Errorf("incorrect item at %v", off)
+		}
+		pgno := int(db.order.Uint32(page[off+4:]))
+		size := int(db.order.Uint32(page[off+8:]))
+		return db.overflow(pgno, size)
+	}
+
+	return nil, fmt.Errorf("unsupported item type %v", page[off])
+}
+
+func (db bdbFile) btreeItem(page []byte, off int) ([]byte, error) {
+	if off+3 > len(page) {
+		return nil, fmt.Errorf("incorrect item at %v", off)
+	}
+
+	switch page[off+2] & 0x7f {
+	case bdbKeyData:
+		size := int(db.order.Uint16(page[off:]))
+		if off+3+size > len(page) {
+			return nil, fmt.Errorf("incorrect item at %v", off)
+		}
+		return page[off+3 : off+3+size], nil
+
+	case bdbOffPage:
+		if off+12 > len(page) {
+			return nil, fmt.Errorf("incorrect item at %v", off)
+		}
+		pgno := int(db.order.Uint32(page[off+4:]))
+		size := int(db.order.Uint32(page[off+8:]))
+		return db.overflow(pgno, size)
+	}
+
+	return nil, fmt.Errorf("unsupported item type %v", page[off+2])
+}
+
+// overflow assembles a value stored in a chain of overflow pages.
+func (db bdbFile) overflow(pgno int, size int) ([]byte, error) {
+	if size > len(db.data) {
+		return nil, fmt.Errorf("overflow item size %v is larger than the file", size)
+	}
+	res := bytes.NewBuffer(make([]byte, 0, size))
+
+	for n := 0; pgno != 0 && res.Len() < size; n++ {
+		if (pgno+1)*db.pageSize > len(db.data) || n > len(db.data)/db.pageSize {
+			return nil, fmt.Errorf("overflow page %v is out of file", pgno)
+		}
+
+		page := db.page(pgno)
+		if page[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("page %v is not an overflow page", pgno)
+		}
+
+		// For overflow pages hf_offset holds the length of the data
+		l := int(db.order.Uint16(page[22:24]))
+		if bdbPageHeaderSize+l > len(page) {
+			return nil, fmt.Errorf("overflow page %v has incorrect length", pgno)
+		}
+
+		res.Write(page[bdbPageHeaderSize : bdbPageHeaderSize+l])
+		pgno = int(db.order.Uint32(page[16:20]))
+	}
+
+	if res.Len() != size {
+		return nil, fmt.Errorf("overflow chain has %v bytes, expected %v", res.Len(), size)
+	}
+
+	return res.Bytes(), nil
+}
@@ -0,0 +1,277 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func rpmDbNEVRAs(headers []*RPMHeader) []string {
+	res := []string{}
+	for _, h := range headers {
+		n := h.NEVRA()
+		if !strings.HasPrefix(n.Name, "filler") {
+			res = append(res, n.Name+"-"+n.FullVersion()+"."+n.Arch)
+		}
+	}
+	return res
+}
+
+// testdata/rpmdb.sqlite is created by sqlite itself. It contains bash,
+// two wine packages (the x86_64 one is big enough to use overflow pages),
+// a gpg-pubkey and 60 "fillerNNN" packages which make the table b-tree
+// have interior pages.
+func TestReadSqliteRpmDb(t *testing.T) {
+	headers, err := ReadRpmDb("testdata")
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "testdata")
+	}
+
+	if len(headers) != 63 {
+		t.Errorf(TMPL_MISMATCH, "count", 63, len(headers))
+	}
+
+	expect := []string{"bash-4.3-1.x86_64", "wine-1:1.7-2.i586", "wine-1:1.7-2.x86_64"}
+	if res := rpmDbNEVRAs(headers); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "NEVRA", expect, res)
+	}
+
+	bash := headers[0].Package()
+	expectFiles := []string{"/bin/bash", "/bin/sh"}
+	if res := headers[0].Files(); !reflect.DeepEqual(res, expectFiles) {
+		t.Errorf(TMPL_MISMATCH, "files", expectFiles, res)
+	}
+
+	expectDeps := []Dependency{{Name: "bash", Flags: "==", EVR: "4.3-1"}, {Name: "/bin/sh"}}
+	if !reflect.DeepEqual(bash.Provides, expectDeps) {
+		t.Errorf(TMPL_MISMATCH, "provides", expectDeps, bash.Provides)
+	}
+
+	wine := headers[1].Package()
+	expectDeps = []Dependency{{Name: "/bin/sh"}, {Name: "libc.so.6"}, {Name: "wine-common", Flags: ">=", EVR: "1.7"}}
+	if !reflect.DeepEqual(wine.Requires, expectDeps) {
+		t.Errorf(TMPL_MISMATCH, "requires", expectDeps, wine.Requires)
+	}
+
+	if res := len(headers[2].String(RPMTAG_DESCRIPTION)); res != 20000 {
+		t.Errorf(TMPL_MISMATCH, "description length", 20000, res)
+	}
+}
+
+// testdata/wal is a copy of testdata/rpmdb.sqlite switched to WAL mode.
+// The deletion of bash was written by sqlite to rpmdb.sqlite-wal and was
+// not checkpointed.
+func TestReadSqliteRpmDbWal(t *testing.T) {
+	headers, err := ReadRpmDb("testdata/wal")
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "testdata/wal")
+	}
+
+	if len(headers) != 62 {
+		t.Errorf(TMPL_MISMATCH, "count", 62, len(headers))
+	}
+
+	expect := []string{"wine-1:1.7-2.i586", "wine-1:1.7-2.x86_64"}
+	if res := rpmDbNEVRAs(headers); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "NEVRA", expect, res)
+	}
+
+	data, err := ioutil.ReadFile("testdata/wal/rpmdb.sqlite")
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "testdata/wal")
+	}
+
+	// A log with a broken frame is applied up to the last good commit
+	wal, err := ioutil.ReadFile("testdata/wal/rpmdb.sqlite-wal")
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "testdata/wal")
+	}
+	wal[len(wal)-1] ^= 0xff
+
+	res, err := applySqliteWal(data, wal)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "testdata/wal")
+	}
+
+	if !bytes.Equal(res, data) {
+		t.Errorf("Uncommitted frames were applied")
+	}
+}
+
+func TestSqliteRecordNegativeVarint(t *testing.T) {
+	negative := bytes.Repeat([]byte{0xff}, 9)
+
+	if _, err := sqliteRecord(negative); err == nil {
+		t.Errorf("Negative header size was accepted")
+	}
+
+	record := append([]byte{10}, negative...)
+	if _, err := sqliteRecord(record); err == nil {
+		t.Errorf("Negative serial type was accepted")
+	}
+}
+
+func TestSqliteCorruptCell(t *testing.T) {
+	const pageSize = 512
+	// Half of every page is reserved, so the looped chain isn't stopped
+	// by the payload size
+	db := &sqliteDb{data: make([]byte, 4*pageSize), pageSize: pageSize, usable: pageSize / 2}
+
+	// The overflow page 2 points to itself
+	binary.BigEndian.PutUint32(db.data[pageSize:], 2)
+	cell := make([]byte, pageSize)
+	for i := 0; i+4 <= len(cell); i += 4 {
+		binary.BigEndian.PutUint32(cell[i:], 2)
+	}
+
+	for _, size := range []int{-1, len(db.data) + 1, 1 << 40, len(db.data)} {
+		if _, err := db.payload(cell, size); err == nil {
+			t.Errorf("Corrupt cell with payload size %v was accepted", size)
+		}
+	}
+
+	bdb := bdbFile{data: make([]byte, pageSize), order: binary.LittleEndian, pageSize: pageSize}
+	if _, err := bdb.overflow(1, 1<<31); err == nil {
+		t.Errorf("Overflow item larger than the file was accepted")
+	}
+}
+
+func TestInstalledFileProvides(t *testing.T) {
+	oldDir := RpmDbDir
+	defer func() { RpmDbDir = oldDir }()
+	RpmDbDir = "testdata"
+	pkgs, err := fillInstalledInfo()
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, RpmDbDir)
+	}
+
+	for _, pkg := range pkgs {
+		if pkg.Name != "bash" {
+			continue
+		}
+
+		expect := []Dependency{{Name: "bash", Flags: "==", EVR: "4.3-1"}, {Name: "/bin/sh"}, {Name: "/bin/bash"}}
+		if !reflect.DeepEqual(pkg.Provides, expect) {
+			t.Errorf(TMPL_MISMATCH, "provides", expect, pkg.Provides)
+		}
+		return
+	}
+	t.Errorf("bash not found")
+}
+
+// writeBdbHash writes a little-endian Berkeley DB hash file with one
+// bucket page. Values which don't fit into the page go to overflow pages.
+func writeBdbHash(file string, values [][]byte) error {
+	const pageSize = 512
+	pages := [][]byte{make([]byte, pageSize), make([]byte, pageSize)}
+
+	meta := pages[0]
+	binary.LittleEndian.PutUint32(meta[12:], bdbHashMagic)
+	binary.LittleEndian.PutUint32(meta[20:], pageSize)
+	meta[25] = 8
+
+	bucket := pages[1]
+	binary.LittleEndian.PutUint32(bucket[8:], 1)
+	bucket[25] = bdbPageHash
+
+	end := pageSize
+	entries := 0
+	addItem := func(item []byte) {
+		end -= len(item)
+		copy(bucket[end:], item)
+		binary.LittleEndian.PutUint16(bucket[bdbPageHeaderSize+entries*2:], uint16(end))
+		entries++
+	}
+
+	for i, v := range values {
+		key := make([]byte, 5)
+		key[0] = bdbKeyData
+		binary.LittleEndian.PutUint32(key[1:], uint32(i))
+		addItem(key)
+
+		if len(v) < 64 {
+			addItem(append([]byte{bdbKeyData}, v...))
+			continue
+		}
+
+		item := make([]byte, 12)
+		item[0] = bdbOffPage
+		binary.LittleEndian.PutUint32(item[4:], uint32(len(pages)))
+		binary.LittleEndian.PutUint32(item[8:], uint32(len(v)))
+		addItem(item)
+
+		for len(v) > 0 {
+			n := len(v)
+			if n > pageSize-bdbPageHeaderSize {
+				n = pageSize - bdbPageHeaderSize
+			}
+
+			page := make([]byte, pageSize)
+			binary.LittleEndian.PutUint32(page[8:], uint32(len(pages)))
+			page[25] = bdbPageOverflow
+			binary.LittleEndian.PutUint16(page[22:], uint16(n))
+			copy(page[bdbPageHeaderSize:], v[:n])
+			v = v[n:]
+
+			if len(v) > 0 {
+				binary.LittleEndian.PutUint32(page[16:], uint32(len(pages)+1))
+			}
+			pages = append(pages, page)
+		}
+	}
+	binary.LittleEndian.PutUint16(bucket[20:], uint16(entries))
+	binary.LittleEndian.PutUint32(meta[32:], uint32(len(pages)-1))
+
+	data := []byte{}
+	for _, p := range pages {
+		data = append(data, p...)
+	}
+	return ioutil.WriteFile(file, data, 0666)
+}
+
+func TestReadBdbRpmDb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	values := [][]byte{{1, 0, 0, 0}}
+	for i, name := range []string{"bash", "flacon", "gpg-pubkey"} {
+		values = append(values, encodeRPMHeader([]testTag{
+			{RPMTAG_NAME, RPM_STRING_TYPE, name},
+			{RPMTAG_VERSION, RPM_STRING_TYPE, fmt.Sprintf("%d.0", i+1)},
+			{RPMTAG_RELEASE, RPM_STRING_TYPE, "1"},
+			{RPMTAG_ARCH, RPM_STRING_TYPE, "x86_64"},
+			{RPMTAG_DESCRIPTION, RPM_I18NSTRING_TYPE, []string{strings.Repeat("d", i*1000)}},
+		}))
+	}
+
+	if err := writeBdbHash(dir+"/Packages", values); err != nil {
+		t.Fatal("Can't ceate Packages file:", err)
+	}
+
+	headers, err := ReadRpmDb(dir)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+
+	expect := []string{"bash-1.0-1.x86_64", "flacon-2.0-1.x86_64"}
+	if res := rpmDbNEVRAs(headers); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "NEVRA", expect, res)
+	}
+
+	if len(headers) == 2 && len(headers[1].String(RPMTAG_DESCRIPTION)) != 1000 {
+		t.Errorf(TMPL_MISMATCH, "description length", 1000, len(headers[1].String(RPMTAG_DESCRIPTION)))
+	}
+}
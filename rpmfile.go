@@ -0,0 +1,151 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	RPMLEAD_BINARY = 0
+	RPMLEAD_SOURCE = 1
+
+	rpmLeadSize = 96
+)
+
+var rpmLeadMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+
+// RPMLead is the obsolete 96-byte header at the start of an .rpm file.
+type RPMLead struct {
+	Major         int
+	Minor         int
+	Type          int
+	Arch          int
+	Name          string
+	OS            int
+	SignatureType int
+}
+
+// RPMFile is a parsed .rpm file. The offsets are used for signature
+// verification: the main header is file[HeaderOffset:PayloadOffset].
+type RPMFile struct {
+	Lead          RPMLead
+	Signature     *RPMHeader
+	Header        *RPMHeader
+	HeaderOffset  int64
+	PayloadOffset int64
+	Size          int64
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadRPMFile parses the lead, the signature header and the main header of
+// an .rpm file. The payload is not read.
+func ReadRPMFile(file string) (*RPMFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ReadRPM(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s: %v", file, err)
+	}
+
+	res.Size = stat.Size()
+	return res, nil
+}
+
+// ReadRPM parses an .rpm stream up to the payload.
+func ReadRPM(r io.Reader) (*RPMFile, error) {
+	cr := &countingReader{r: r}
+	res := &RPMFile{}
+
+	lead := make([]byte, rpmLeadSize)
+	if _, err := io.ReadFull(cr, lead); err != nil {
+		return nil, fmt.Errorf("can't read lead: %v", err)
+	}
+
+	if !bytes.Equal(lead[:4], rpmLeadMagic) {
+		return nil, fmt.Errorf("not an RPM file")
+	}
+
+	res.Lead = RPMLead{
+		Major:         int(lead[4]),
+		Minor:         int(lead[5]),
+		Type:          int(binary.BigEndian.Uint16(lead[6:8])),
+		Arch:          int(binary.BigEndian.Uint16(lead[8:10])),
+		Name:          string(bytes.TrimRight(lead[10:76], "\x00")),
+		OS:            int(binary.BigEndian.Uint16(lead[76:78])),
+		SignatureType: int(binary.BigEndian.Uint16(lead[78:80])),
+	}
+
+	if res.Lead.Major < 3 {
+		return nil, fmt.Errorf("unsupported RPM version %v.%v", res.Lead.Major, res.Lead.Minor)
+	}
+
+	var err error
+	res.Signature, err = ReadRPMHeader(cr)
+	if err != nil {
+		return nil, fmt.Errorf("signature: %v", err)
+	}
+
+	// The signature header is padded to 8 bytes
+	if pad := (8 - cr.n%8) % 8; pad > 0 {
+		if _, err := io.ReadFull(cr, make([]byte, pad)); err != nil {
+			return nil, fmt.Errorf("signature: %v", err)
+		}
+	}
+
+	res.HeaderOffset = cr.n
+	res.Header, err = ReadRPMHeader(cr)
+	if err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+	res.PayloadOffset = cr.n
+
+	return res, nil
+}
+
+// IsSource reports whether the file is a source package.
+func (f *RPMFile) IsSource() bool {
+	return f.Lead.Type == RPMLEAD_SOURCE || !f.Header.Has(RPMTAG_SOURCERPM)
+}
+
+// Package converts the file header to a Package.
+func (f *RPMFile) Package(file string) Package {
+	pkg := f.Header.Package()
+	if f.IsSource() {
+		pkg.Arch = "src"
+	}
+
+	pkg.FileName = strings.TrimSuffix(filepath.Base(file), ".rpm")
+	pkg.RPMSize = int(f.Size)
+	return pkg
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// buildTestRPM builds an .rpm file from the signature tags, the main
+// header tags and the payload.
+func buildTestRPM(leadType int, sig []byte, header []byte, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+
+	lead := make([]byte, rpmLeadSize)
+	copy(lead, rpmLeadMagic)
+	lead[4] = 3
+	binary.BigEndian.PutUint16(lead[6:], uint16(leadType))
+	copy(lead[10:], "test-1.0-1")
+	binary.BigEndian.PutUint16(lead[78:], 5)
+	buf.Write(lead)
+
+	buf.Write(append(append([]byte{}, rpmHeaderMagic...), 0, 0, 0, 0))
+	buf.Write(sig)
+	for buf.Len()%8 != 0 {
+		buf.WriteByte(0)
+	}
+
+	buf.Write(append(append([]byte{}, rpmHeaderMagic...), 0, 0, 0, 0))
+	buf.Write(header)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func testRPMHeaderTags(name string) []testTag {
+	return []testTag{
+		{RPMTAG_NAME, RPM_STRING_TYPE, name},
+		{RPMTAG_VERSION, RPM_STRING_TYPE, "1.2.0"},
+		{RPMTAG_RELEASE, RPM_STRING_TYPE, "1"},
+		{RPMTAG_SUMMARY, RPM_I18NSTRING_TYPE, []string{"Audio File Encoder"}},
+		{RPMTAG_SIZE, RPM_INT32_TYPE, []int{12345}},
+		{RPMTAG_ARCH, RPM_STRING_TYPE, "x86_64"},
+		{RPMTAG_SOURCERPM, RPM_STRING_TYPE, name + "-1.2.0-1.src.rpm"},
+		{RPMTAG_PROVIDENAME, RPM_STRING_ARRAY_TYPE, []string{name}},
+		{RPMTAG_PROVIDEFLAGS, RPM_INT32_TYPE, []int{RPMSENSE_EQUAL}},
+		{RPMTAG_PROVIDEVERSION, RPM_STRING_ARRAY_TYPE, []string{"1.2.0-1"}},
+		{RPMTAG_DIRNAMES, RPM_STRING_ARRAY_TYPE, []string{"/usr/bin/"}},
+		{RPMTAG_BASENAMES, RPM_STRING_ARRAY_TYPE, []string{name}},
+		{RPMTAG_DIRINDEXES, RPM_INT32_TYPE, []int{0}},
+	}
+}
+
+func TestReadRPMFile(t *testing.T) {
+	sig := encodeRPMHeader([]testTag{
+		{1000, RPM_INT32_TYPE, []int{1234}},
+	})
+	header := encodeRPMHeader(testRPMHeaderTags("flacon"))
+	data := buildTestRPM(RPMLEAD_BINARY, sig, header, []byte("payload"))
+
+	f, err := ioutil.TempFile("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp file:", err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(data)
+	f.Close()
+
+	rpm, err := ReadRPMFile(f.Name())
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, f.Name())
+	}
+
+	if rpm.Lead.Name != "test-1.0-1" || rpm.Lead.SignatureType != 5 {
+		t.Errorf(TMPL_MISMATCH, "lead", "test-1.0-1", rpm.Lead)
+	}
+
+	if v, _ := rpm.Signature.Int(1000); v != 1234 {
+		t.Errorf(TMPL_MISMATCH, "signature size", 1234, v)
+	}
+
+	if !bytes.Equal(data[rpm.HeaderOffset:rpm.PayloadOffset], append(append([]byte{}, rpmHeaderMagic...), append([]byte{0, 0, 0, 0}, header...)...)) {
+		t.Errorf("Header offsets are incorrect: %v-%v", rpm.HeaderOffset, rpm.PayloadOffset)
+	}
+
+	if string(data[rpm.PayloadOffset:]) != "payload" {
+		t.Errorf(TMPL_MISMATCH, "payload", "payload", string(data[rpm.PayloadOffset:]))
+	}
+
+	pkg := rpm.Package(f.Name())
+	if pkg.Name != "flacon" || pkg.Version != "1.2.0-1" || pkg.Arch != "x86_64" || pkg.Size != 12345 {
+		t.Errorf(TMPL_MISMATCH, "package", "flacon-1.2.0-1.x86_64", pkg)
+	}
+
+	if pkg.RPMSize != len(data) {
+		t.Errorf(TMPL_MISMATCH, "RPM size", len(data), pkg.RPMSize)
+	}
+
+	if res := rpm.Header.Files(); !reflect.DeepEqual(res, []string{"/usr/bin/flacon"}) {
+		t.Errorf(TMPL_MISMATCH, "files", []string{"/usr/bin/flacon"}, res)
+	}
+
+	src := buildTestRPM(RPMLEAD_SOURCE, sig, header, nil)
+	rpm, err = ReadRPM(bytes.NewReader(src))
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "source rpm")
+	}
+
+	if pkg := rpm.Package("flacon-1.2.0-1.src.rpm"); pkg.Arch != "src" {
+		t.Errorf(TMPL_MISMATCH, "source arch", "src", pkg.Arch)
+	}
+
+	if _, err := ReadRPM(bytes.NewReader(data[:200])); err == nil {
+		t.Errorf("Truncated file must be rejected")
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// RPM header tag types
+const (
+	RPM_NULL_TYPE         = 0
+	RPM_CHAR_TYPE         = 1
+	RPM_INT8_TYPE         = 2
+	RPM_INT16_TYPE        = 3
+	RPM_INT32_TYPE        = 4
+	RPM_INT64_TYPE        = 5
+	RPM_STRING_TYPE       = 6
+	RPM_BIN_TYPE          = 7
+	RPM_STRING_ARRAY_TYPE = 8
+	RPM_I18NSTRING_TYPE   = 9
+)
+
+// RPM header tags
+const (
+	RPMTAG_NAME              = 1000
+	RPMTAG_VERSION           = 1001
+	RPMTAG_RELEASE           = 1002
+	RPMTAG_EPOCH             = 1003
+	RPMTAG_SUMMARY           = 1004
+	RPMTAG_DESCRIPTION       = 1005
+	RPMTAG_BUILDTIME         = 1006
+	RPMTAG_SIZE              = 1009
+	RPMTAG_LICENSE           = 1014
+	RPMTAG_GROUP             = 1016
+	RPMTAG_URL               = 1020
+	RPMTAG_ARCH              = 1022
+	RPMTAG_OLDFILENAMES      = 1027
+	RPMTAG_SOURCERPM         = 1044
+	RPMTAG_PROVIDENAME       = 1047
+	RPMTAG_REQUIREFLAGS      = 1048
+	RPMTAG_REQUIRENAME       = 1049
+	RPMTAG_REQUIREVERSION    = 1050
+	RPMTAG_CONFLICTFLAGS     = 1053
+	RPMTAG_CONFLICTNAME      = 1054
+	RPMTAG_CONFLICTVERSION   = 1055
+	RPMTAG_OBSOLETENAME      = 1090
+	RPMTAG_PROVIDEFLAGS      = 1112
+	RPMTAG_PROVIDEVERSION    = 1113
+	RPMTAG_OBSOLETEFLAGS     = 1114
+	RPMTAG_OBSOLETEVERSION   = 1115
+	RPMTAG_DIRINDEXES        = 1116
+	RPMTAG_BASENAMES         = 1117
+	RPMTAG_DIRNAMES          = 1118
+	RPMTAG_PAYLOADFORMAT     = 1124
+	RPMTAG_PAYLOADCOMPRESSOR = 1125
+	RPMTAG_DISTTAG           = 1155
+	RPMTAG_DISTEPOCH         = 1218
+	RPMTAG_SUGGESTNAME       = 5049
+	RPMTAG_SUGGESTVERSION    = 5050
+	RPMTAG_SUGGESTFLAGS      = 5051
+	RPMTAG_PAYLOADDIGEST     = 5092
+	RPMTAG_PAYLOADDIGESTALGO = 5093
+)
+
+// Dependency sense flags
+const (
+	RPMSENSE_LESS    = 0x02
+	RPMSENSE_GREATER = 0x04
+	RPMSENSE_EQUAL   = 0x08
+)
+
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+type rpmTag struct {
+	Type  int
+	Count int
+	Data  []byte
+}
+
+// RPMHeader is a decoded RPM header: a set of typed tags.
+type RPMHeader struct {
+	tags map[int]rpmTag
+}
+
+// ReadRPMHeader reads a header with the 8-byte magic prefix, as stored in
+// .rpm files.
+func ReadRPMHeader(r io.Reader) (*RPMHeader, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("can't read header: %v", err)
+	}
+
+	if !bytes.Equal(buf[:4], rpmHeaderMagic) {
+		return nil, fmt.Errorf("incorrect header magic")
+	}
+
+	il := int(binary.BigEndian.Uint32(buf[8:12]))
+	dl := int(binary.BigEndian.Uint32(buf[12:16]))
+	if il < 0 || dl < 0 || il > 0xffff || dl > 256*1024*1024 {
+		return nil, fmt.Errorf("header is too big: %v tags, %v bytes", il, dl)
+	}
+
+	blob := make([]byte, 8+il*16+dl)
+	copy(blob, buf[8:16])
+	if _, err := io.ReadFull(r, blob[8:]); err != nil {
+		return nil, fmt.Errorf("can't read header: %v", err)
+	}
+
+	return ParseRPMHeaderBlob(blob)
+}
+
+// ParseRPMHeaderBlob decodes a header without the magic prefix, as stored
+// in the rpm database.
+func ParseRPMHeaderBlob(blob []byte) (*RPMHeader, error) {
+	if len(blob) < 8 {
+		return nil, fmt.Errorf("header is too short")
+	}
+
+	il := int(binary.BigEndian.Uint32(blob[0:4]))
+	dl := int(binary.BigEndian.Uint32(blob[4:8]))
+	if il < 0 || dl < 0 || len(blob) < 8+il*16+dl {
+		return nil, fmt.Errorf("header is truncated: %v tags, %v bytes", il, dl)
+	}
+
+	index := blob[8 : 8+il*16]
+	data := blob[8+il*16 : 8+il*16+dl]
+
+	h := &RPMHeader{tags: map[int]rpmTag{}}
+	for i := 0; i < il; i++ {
+		e := index[i*16:]
+		tag := int(binary.BigEndian.Uint32(e[0:4]))
+		typ := int(binary.BigEndian.Uint32(e[4:8]))
+		offset := int(binary.BigEndian.Uint32(e[8:12]))
+		count := int(binary.BigEndian.Uint32(e[12:16]))
+
+		if offset < 0 || offset > len(data) {
+			return nil, fmt.Errorf("tag %v has incorrect offset %v", tag, offset)
+		}
+
+		h.tags[tag] = rpmTag{Type: typ, Count: count, Data: data[offset:]}
+	}
+
+	return h, nil
+}
+
+// Has reports whether the header contains the tag.
+func (h *RPMHeader) Has(tag int) bool {
+	_, ok := h.tags[tag]
+	return ok
+}
+
+// Strings returns the value of a string, string array or i18n string tag.
+func (h *RPMHeader) Strings(tag int) []string {
+	t, ok := h.tags[tag]
+	if !ok {
+		return nil
+	}
+
+	switch t.Type {
+	case RPM_STRING_TYPE, RPM_STRING_ARRAY_TYPE, RPM_I18NSTRING_TYPE:
+	default:
+		return nil
+	}
+
+	count := t.Count
+	if t.Type == RPM_STRING_TYPE {
+		count = 1
+	}
+
+	// Every string takes at least one byte, the count of a broken or
+	// crafted header can be anything
+	if count > len(t.Data) {
+		count = len(t.Data)
+	}
+
+	res := make([]string, 0, count)
+	data := t.Data
+	for i := 0; i < count; i++ {
+		n := bytes.IndexByte(data, 0)
+		if n < 0 {
+			break
+		}
+		res = append(res, string(data[:n]))
+		data = data[n+1:]
+	}
+
+	return res
+}
+
+// String returns the value of a string tag. For i18n strings the first
+// (untranslated) value is returned.
+func (h *RPMHeader) String(tag int) string {
+	s := h.Strings(tag)
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+// Ints returns the value of an integer tag.
+func (h *RPMHeader) Ints(tag int) []int64 {
+	t, ok := h.tags[tag]
+	if !ok {
+		return nil
+	}
+
+	size := 0
+	switch t.Type {
+	case RPM_CHAR_TYPE, RPM_INT8_TYPE:
+		size = 1
+	case RPM_INT16_TYPE:
+		size = 2
+	case RPM_INT32_TYPE:
+		size = 4
+	case RPM_INT64_TYPE:
+		size = 8
+	default:
+		return nil
+	}
+
+	if len(t.Data) < size*t.Count {
+		return nil
+	}
+
+	res := make([]int64, t.Count)
+	for i := range res {
+		d := t.Data[i*size:]
+		switch size {
+		case 1:
+			res[i] = int64(d[0])
+		case 2:
+			res[i] = int64(binary.BigEndian.Uint16(d))
+		case 4:
+			res[i] = int64(binary.BigEndian.Uint32(d))
+		case 8:
+			res[i] = int64(binary.BigEndian.Uint64(d))
+		}
+	}
+
+	return res
+}
+
+// Int returns the first value of an integer tag.
+func (h *RPMHeader) Int(tag int) (int64, bool) {
+	v := h.Ints(tag)
+	if len(v) == 0 {
+		return 0, false
+	}
+	return v[0], true
+}
+
+// Bytes returns the raw value of a binary tag.
+func (h *RPMHeader) Bytes(tag int) []byte {
+	t, ok := h.tags[tag]
+	if !ok || t.Type != RPM_BIN_TYPE || len(t.Data) < t.Count {
+		return nil
+	}
+	return t.Data[:t.Count]
+}
+
+// NEVRA returns name, epoch, version-release and arch of the package.
+func (h *RPMHeader) NEVRA() NEVRA {
+	n := NEVRA{
+		Name:    h.String(RPMTAG_NAME),
+		Version: h.String(RPMTAG_VERSION) + "-" + h.String(RPMTAG_RELEASE),
+		Arch:    h.String(RPMTAG_ARCH),
+	}
+
+	if e, ok := h.Int(RPMTAG_EPOCH); ok {
+		n.Epoch = int(e)
+	}
+
+	return n
+}
+
+// Dependencies builds a dependency list from parallel name/flags/version
+// tags.
+func (h *RPMHeader) Dependencies(nameTag, flagsTag, versionTag int) []Dependency {
+	names := h.Strings(nameTag)
+	flags := h.Ints(flagsTag)
+	versions := h.Strings(versionTag)
+
+	res := make([]Dependency, 0, len(names))
+	for i, name := range names {
+		dep := Dependency{Name: name}
+
+		if i < len(flags) && i < len(versions) && versions[i] != "" {
+			f := flags[i]
+			switch {
+			case f&RPMSENSE_LESS != 0 && f&RPMSENSE_EQUAL != 0:
+				dep.Flags = "<="
+			case f&RPMSENSE_GREATER != 0 && f&RPMSENSE_EQUAL != 0:
+				dep.Flags = ">="
+			case f&RPMSENSE_LESS != 0:
+				dep.Flags = "<"
+			case f&RPMSENSE_GREATER != 0:
+				dep.Flags = ">"
+			case f&RPMSENSE_EQUAL != 0:
+				dep.Flags = "=="
+			}
+
+			if dep.Flags != "" {
+				dep.EVR = versions[i]
+			}
+		}
+
+		res = append(res, dep)
+	}
+
+	return res
+}
+
+// Files returns the full paths of the files in the package.
+func (h *RPMHeader) Files() []string {
+	if old := h.Strings(RPMTAG_OLDFILENAMES); len(old) > 0 {
+		return old
+	}
+
+	basenames := h.Strings(RPMTAG_BASENAMES)
+	dirnames := h.Strings(RPMTAG_DIRNAMES)
+	dirindexes := h.Ints(RPMTAG_DIRINDEXES)
+
+	res := make([]string, 0, len(basenames))
+	for i, base := range basenames {
+		if i >= len(dirindexes) || int(dirindexes[i]) >= len(dirnames) {
+			break
+		}
+		res = append(res, dirnames[dirindexes[i]]+base)
+	}
+
+	return res
+}
+
+// Package converts the header to a Package.
+func (h *RPMHeader) Package() Package {
+	n := h.NEVRA()
+
+	pkg := Package{
+		Name:        n.Name,
+		Epoch:       n.Epoch,
+		Version:     n.Version,
+		Arch:        n.Arch,
+		Summary:     h.String(RPMTAG_SUMMARY),
+		Description: h.String(RPMTAG_DESCRIPTION),
+		Group:       h.String(RPMTAG_GROUP),
+		License:     h.String(RPMTAG_LICENSE),
+		URL:         h.String(RPMTAG_URL),
+		Sourcerpm:   h.String(RPMTAG_SOURCERPM),
+		Disttag:     h.String(RPMTAG_DISTTAG),
+		Distepoch:   h.String(RPMTAG_DISTEPOCH),
+
+		Provides:  h.Dependencies(RPMTAG_PROVIDENAME, RPMTAG_PROVIDEFLAGS, RPMTAG_PROVIDEVERSION),
+		Requires:  h.Dependencies(RPMTAG_REQUIRENAME, RPMTAG_REQUIREFLAGS, RPMTAG_REQUIREVERSION),
+		Conflicts: h.Dependencies(RPMTAG_CONFLICTNAME, RPMTAG_CONFLICTFLAGS, RPMTAG_CONFLICTVERSION),
+		Obsoletes: h.Dependencies(RPMTAG_OBSOLETENAME, RPMTAG_OBSOLETEFLAGS, RPMTAG_OBSOLETEVERSION),
+		Suggests:  h.Dependencies(RPMTAG_SUGGESTNAME, RPMTAG_SUGGESTFLAGS, RPMTAG_SUGGESTVERSION),
+	}
+
+	if size, ok := h.Int(RPMTAG_SIZE); ok {
+		pkg.Size = int(size)
+	}
+
+	return pkg
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type testTag struct {
+	tag   int
+	typ   int
+	value interface{}
+}
+
+// encodeRPMHeader builds a header blob (without the magic) from tags.
+func encodeRPMHeader(tags []testTag) []byte {
+	index := &bytes.Buffer{}
+	data := &bytes.Buffer{}
+	for _, t := range tags {
+		align := map[int]int{RPM_INT16_TYPE: 2, RPM_INT32_TYPE: 4, RPM_INT64_TYPE: 8}[t.typ]
+		for align > 0 && data.Len()%align != 0 {
+			data.WriteByte(0)
+		}
+
+		offset := data.Len()
+		count := 0
+		switch v := t.value.(type) {
+		case string:
+			data.WriteString(v + "\x00")
+			count = 1
+		case []string:
+			for _, s := range v {
+				data.WriteString(s + "\x00")
+			}
+			count = len(v)
+		case []int:
+			for _, i := range v {
+				binary.Write(data, binary.BigEndian, uint32(i))
+			}
+			count = len(v)
+		case []byte:
+			data.Write(v)
+			count = len(v)
+		}
+
+		binary.Write(index, binary.BigEndian, []uint32{uint32(t.tag), uint32(t.typ), uint32(offset), uint32(count)})
+	}
+
+	res := &bytes.Buffer{}
+	binary.Write(res, binary.BigEndian, []uint32{uint32(len(tags)), uint32(data.Len())})
+	res.Write(index.Bytes())
+	res.Write(data.Bytes())
+	return res.Bytes()
+}
+
+func TestRPMHeader(t *testing.T) {
+	blob := encodeRPMHeader([]testTag{
+		{RPMTAG_NAME, RPM_STRING_TYPE, "boomaga"},
+		{RPMTAG_VERSION, RPM_STRING_TYPE, "0.7.1"},
+		{RPMTAG_RELEASE, RPM_STRING_TYPE, "1"},
+		{RPMTAG_EPOCH, RPM_INT32_TYPE, []int{2}},
+		{RPMTAG_ARCH, RPM_STRING_TYPE, "x86_64"},
+		{RPMTAG_SUMMARY, RPM_I18NSTRING_TYPE, []string{"Virtual printer", "Виртуальный принтер"}},
+		{RPMTAG_REQUIRENAME, RPM_STRING_ARRAY_TYPE, []string{"libc.so.6", "boomaga-data", "cups"}},
+		{RPMTAG_REQUIREFLAGS, RPM_INT32_TYPE, []int{0, RPMSENSE_EQUAL, RPMSENSE_GREATER | RPMSENSE_EQUAL}},
+		{RPMTAG_REQUIREVERSION, RPM_STRING_ARRAY_TYPE, []string{"", "2:0.7.1-1", "1.4"}},
+		{RPMTAG_DIRNAMES, RPM_STRING_ARRAY_TYPE, []string{"/usr/bin/", "/usr/share/boomaga/"}},
+		{RPMTAG_BASENAMES, RPM_STRING_ARRAY_TYPE, []string{"boomaga", "a.png", "b.png"}},
+		{RPMTAG_DIRINDEXES, RPM_INT32_TYPE, []int{0, 1, 1}},
+	})
+
+	h, err := ParseRPMHeaderBlob(blob)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "header")
+	}
+
+	expectNEVRA := NEVRA{Name: "boomaga", Epoch: 2, Version: "0.7.1-1", Arch: "x86_64"}
+	if res := h.NEVRA(); res != expectNEVRA {
+		t.Errorf(TMPL_MISMATCH, "NEVRA", expectNEVRA, res)
+	}
+
+	if res := h.String(RPMTAG_SUMMARY); res != "Virtual printer" {
+		t.Errorf(TMPL_MISMATCH, "summary", "Virtual printer", res)
+	}
+
+	expectDeps := []Dependency{
+		{Name: "libc.so.6"},
+		{Name: "boomaga-data", Flags: "==", EVR: "2:0.7.1-1"},
+		{Name: "cups", Flags: ">=", EVR: "1.4"},
+	}
+	if res := h.Dependencies(RPMTAG_REQUIRENAME, RPMTAG_REQUIREFLAGS, RPMTAG_REQUIREVERSION); !reflect.DeepEqual(res, expectDeps) {
+		t.Errorf(TMPL_MISMATCH, "requires", expectDeps, res)
+	}
+
+	expectFiles := []string{"/usr/bin/boomaga", "/usr/share/boomaga/a.png", "/usr/share/boomaga/b.png"}
+	if res := h.Files(); !reflect.DeepEqual(res, expectFiles) {
+		t.Errorf(TMPL_MISMATCH, "files", expectFiles, res)
+	}
+
+	if _, err := ParseRPMHeaderBlob(blob[:len(blob)-10]); err == nil {
+		t.Errorf("Truncated header must be rejected")
+	}
+}
+
+func TestMalformedRPMHeader(t *testing.T) {
+	// One string array tag with a huge count and 4 bytes of data
+	blob := make([]byte, 8+16+4)
+	binary.BigEndian.PutUint32(blob[0:], 1)
+	binary.BigEndian.PutUint32(blob[4:], 4)
+	binary.BigEndian.PutUint32(blob[8:], RPMTAG_BASENAMES)
+	binary.BigEndian.PutUint32(blob[12:], RPM_STRING_ARRAY_TYPE)
+	binary.BigEndian.PutUint32(blob[16:], 0)
+	binary.BigEndian.PutUint32(blob[20:], 0x7fffffff)
+	copy(blob[24:], "a\x00b\x00")
+
+	h, err := ParseRPMHeaderBlob(blob)
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "header")
+	}
+
+	expect := []string{"a", "b"}
+	if res := h.Strings(RPMTAG_BASENAMES); !reflect.DeepEqual(res, expect) {
+		t.Errorf(TMPL_MISMATCH, "strings", expect, res)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// RPM signature header tags
+const (
+	RPMSIGTAG_DSA    = 267  // DSA signature of the header
+	RPMSIGTAG_RSA    = 268  // RSA signature of the header
+	RPMSIGTAG_SHA1   = 269  // SHA1 of the header, hex string
+	RPMSIGTAG_SHA256 = 273  // SHA256 of the header, hex string
+	RPMSIGTAG_SIZE   = 1000 // Size of the header and the payload
+	RPMSIGTAG_PGP    = 1002 // RSA signature of the header and the payload
+	RPMSIGTAG_MD5    = 1004 // MD5 of the header and the payload
+	RPMSIGTAG_GPG    = 1005 // DSA signature of the header and the payload
+)
+
+var (
+	GpgKeyDir = "/etc/pki/rpm-gpg"
+)
+
+// LoadKeyring collects the trusted public keys from the files in keyDir and
+// the gpg-pubkey entries of the rpm database in dbDir.
+func LoadKeyring(keyDir, dbDir string) (openpgp.EntityList, error) {
+	res := openpgp.EntityList{}
+
+	files, err := ioutil.ReadDir(keyDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(keyDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		keys, err := readKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read key %s: %v", filepath.Join(keyDir, f.Name()), err)
+		}
+		res = append(res, keys...)
+	}
+
+	pubkeys, err := ReadRpmDbPubkeys(dbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range pubkeys {
+		keys, err := readKeys([]byte(h.String(RPMTAG_DESCRIPTION)))
+		if err != nil {
+			return nil, fmt.Errorf("Can't read key %s-%s from rpm database: %v",
+				h.String(RPMTAG_VERSION), h.String(RPMTAG_RELEASE), err)
+		}
+		res = append(res, keys...)
+	}
+
+	return res, nil
+}
+
+// readKeys parses an armored or a binary key ring.
+func readKeys(data []byte) (openpgp.EntityList, error) {
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err == nil {
+		return keys, nil
+	}
+
+	if keys, err2 := openpgp.ReadKeyRing(bytes.NewReader(data)); err2 == nil {
+		return keys, nil
+	}
+
+	return nil, err
+}
+
+// VerifyRPMSignature checks the digests and the signatures stored in the
+// signature header of the .rpm file against the keyring. The package is
+// accepted only if it carries at least one signature, all of its
+// signatures are made by known keys and all digests match. The signer of
+// the package is returned.
+func VerifyRPMSignature(file string, keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	rpm, err := ReadRPM(io.NewSectionReader(f, 0, stat.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s: %v", file, err)
+	}
+	rpm.Size = stat.Size()
+
+	signer, err := rpm.verifySignature(f, keyring)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return signer, nil
+}
+
+func (rpm *RPMFile) verifySignature(f io.ReaderAt, keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+	header := func() io.Reader {
+		return io.NewSectionReader(f, rpm.HeaderOffset, rpm.PayloadOffset-rpm.HeaderOffset)
+	}
+
+	headerAndPayload := func() io.Reader {
+		return io.NewSectionReader(f, rpm.HeaderOffset, rpm.Size-rpm.HeaderOffset)
+	}
+
+	payload := func() io.Reader {
+		return io.NewSectionReader(f, rpm.PayloadOffset, rpm.Size-rpm.PayloadOffset)
+	}
+
+	sig := rpm.Signature
+
+	// Digests ..............................
+	if size, ok := sig.Int(RPMSIGTAG_SIZE); ok && size != rpm.Size-rpm.HeaderOffset {
+		return nil, fmt.Errorf("size mismatch: expected %v, got %v", size, rpm.Size-rpm.HeaderOffset)
+	}
+
+	if sum := sig.Bytes(RPMSIGTAG_MD5); sum != nil {
+		if err := checkDigest("MD5", md5.New(), headerAndPayload(), hex.EncodeToString(sum)); err != nil {
+			return nil, err
+		}
+	}
+
+	if sum := sig.String(RPMSIGTAG_SHA1); sum != "" {
+		if err := checkDigest("header SHA1", sha1.New(), header(), sum); err != nil {
+			return nil, err
+		}
+	}
+
+	if sum := sig.String(RPMSIGTAG_SHA256); sum != "" {
+		if err := checkDigest("header SHA256", sha256.New(), header(), sum); err != nil {
+			return nil, err
+		}
+	}
+
+	if sums := rpm.Header.Strings(RPMTAG_PAYLOADDIGEST); len(sums) > 0 {
+		algo, _ := rpm.Header.Int(RPMTAG_PAYLOADDIGESTALGO)
+		h := pgpHash(algo)
+		if h == nil {
+			return nil, fmt.Errorf("unsupported payload digest algorithm %v", algo)
+		}
+
+		if err := checkDigest("payload digest", h, payload(), sums[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Signatures ...........................
+	var signer *openpgp.Entity
+	checks := []struct {
+		tag  int
+		data func() io.Reader
+	}{
+		{RPMSIGTAG_RSA, header},
+		{RPMSIGTAG_DSA, header},
+		{RPMSIGTAG_PGP, headerAndPayload},
+		{RPMSIGTAG_GPG, headerAndPayload},
+	}
+
+	for _, c := range checks {
+		s := sig.Bytes(c.tag)
+		if s == nil {
+			continue
+		}
+
+		e, err := openpgp.CheckDetachedSignature(keyring, c.data(), bytes.NewReader(s))
+		if err == pgperrors.ErrUnknownIssuer {
+			return nil, fmt.Errorf("signed with an unknown key %s", signatureKeyId(s))
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("bad signature: %v", err)
+		}
+
+		signer = e
+	}
+
+	if signer == nil {
+		return nil, fmt.Errorf("package is not signed")
+	}
+
+	return signer, nil
+}
+
+func checkDigest(name string, h hash.Hash, r io.Reader, expected string) error {
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+
+	if res := hex.EncodeToString(h.Sum(nil)); res != expected {
+		return fmt.Errorf("%s mismatch: expected %s, got %s", name, expected, res)
+	}
+
+	return nil
+}
+
+// pgpHash returns a hash for the OpenPGP hash algorithm id used by rpm.
+func pgpHash(algo int64) hash.Hash {
+	switch algo {
+	case 1:
+		return md5.New()
+	case 2:
+		return sha1.New()
+	case 8:
+		return sha256.New()
+	case 9:
+		return sha512.New384()
+	case 10:
+		return sha512.New()
+	case 11:
+		return sha256.New224()
+	}
+	return nil
+}
+
+// signatureKeyId returns the issuer key id of the signature packet.
+func signatureKeyId(sig []byte) string {
+	p, err := packet.Read(bytes.NewReader(sig))
+	if err != nil {
+		return "unknown"
+	}
+
+	switch s := p.(type) {
+	case *packet.Signature:
+		if s.IssuerKeyId != nil {
+			return fmt.Sprintf("%016X", *s.IssuerKeyId)
+		}
+
+	case *packet.SignatureV3:
+		return fmt.Sprintf("%016X", s.IssuerKeyId)
+	}
+
+	return "unknown"
+}
+
+// SignerName returns a printable description of the key.
+func SignerName(e *openpgp.Entity) string {
+	for name := range e.Identities {
+		return fmt.Sprintf("%s (key ID %s)", name, e.PrimaryKey.KeyIdString())
+	}
+	return "key ID " + e.PrimaryKey.KeyIdString()
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func newTestKey(t *testing.T) *openpgp.Entity {
+	key, err := openpgp.NewEntity("Test Packager", "", "packager@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal("Can't create key:", err)
+	}
+
+	// The self-signatures are only computed while serializing the private key
+	key.SerializePrivate(ioutil.Discard, nil)
+	return key
+}
+
+// buildSignedTestRPM builds an .rpm file with the given signature tags. The
+// signatures and digests are computed over header and payload.
+func buildSignedTestRPM(t *testing.T, key *openpgp.Entity, tags []int, payload []byte) []byte {
+	header := append(append([]byte{}, rpmHeaderMagic...), 0, 0, 0, 0)
+	header = append(header, encodeRPMHeader(testRPMHeaderTags("flacon"))...)
+	all := append(append([]byte{}, header...), payload...)
+
+	sig := []testTag{}
+	for _, tag := range tags {
+		switch tag {
+		case RPMSIGTAG_SIZE:
+			sig = append(sig, testTag{tag, RPM_INT32_TYPE, []int{len(all)}})
+
+		case RPMSIGTAG_MD5:
+			sum := md5.Sum(all)
+			sig = append(sig, testTag{tag, RPM_BIN_TYPE, sum[:]})
+
+		case RPMSIGTAG_SHA1:
+			sum := sha1.Sum(header)
+			sig = append(sig, testTag{tag, RPM_STRING_TYPE, hex.EncodeToString(sum[:])})
+
+		case RPMSIGTAG_RSA, RPMSIGTAG_PGP:
+			data := header
+			if tag == RPMSIGTAG_PGP {
+				data = all
+			}
+
+			buf := &bytes.Buffer{}
+			if err := openpgp.DetachSign(buf, key, bytes.NewReader(data), nil); err != nil {
+				t.Fatal("Can't sign:", err)
+			}
+			sig = append(sig, testTag{tag, RPM_BIN_TYPE, buf.Bytes()})
+		}
+	}
+
+	return buildTestRPM(RPMLEAD_BINARY, encodeRPMHeader(sig), header[8:], payload)
+}
+
+func writeTmpFile(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp file:", err)
+	}
+	f.Write(data)
+	f.Close()
+	return f.Name()
+}
+
+func TestVerifyRPMSignature(t *testing.T) {
+	key := newTestKey(t)
+	other := newTestKey(t)
+	all := []int{RPMSIGTAG_SIZE, RPMSIGTAG_MD5, RPMSIGTAG_SHA1, RPMSIGTAG_RSA, RPMSIGTAG_PGP}
+
+	tamper := func(data []byte) []byte {
+		data[len(data)-1] ^= 0xff
+		return data
+	}
+
+	cases := []struct {
+		name    string
+		data    []byte
+		keyring openpgp.EntityList
+		err     string
+	}{
+		{"valid", buildSignedTestRPM(t, key, all, []byte("payload")), openpgp.EntityList{other, key}, ""},
+		{"header only", buildSignedTestRPM(t, key, []int{RPMSIGTAG_RSA}, []byte("payload")), openpgp.EntityList{key}, ""},
+		{"tampered", tamper(buildSignedTestRPM(t, key, all, []byte("payload"))), openpgp.EntityList{key}, "MD5 mismatch"},
+		{"tampered no digest", tamper(buildSignedTestRPM(t, key, []int{RPMSIGTAG_PGP}, []byte("payload"))), openpgp.EntityList{key}, "bad signature"},
+		{"unknown key", buildSignedTestRPM(t, key, all, []byte("payload")), openpgp.EntityList{other}, "unknown key " + key.PrimaryKey.KeyIdString()},
+		{"unsigned", buildSignedTestRPM(t, key, []int{RPMSIGTAG_MD5}, []byte("payload")), openpgp.EntityList{key}, "not signed"},
+	}
+
+	for _, c := range cases {
+		file := writeTmpFile(t, c.data)
+		defer os.Remove(file)
+
+		signer, err := VerifyRPMSignature(file, c.keyring)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf(TMPL_ERROR, err, c.name)
+			} else if signer.PrimaryKey.KeyId != key.PrimaryKey.KeyId {
+				t.Errorf(TMPL_MISMATCH, c.name, key.PrimaryKey.KeyIdString(), signer.PrimaryKey.KeyIdString())
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf(TMPL_MISMATCH, c.name, c.err, err)
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	key := newTestKey(t)
+
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(dir + "/RPM-GPG-KEY-test")
+	if err != nil {
+		t.Fatal("Can't ceate key file:", err)
+	}
+	w, _ := armor.Encode(f, openpgp.PublicKeyType, nil)
+	key.Serialize(w)
+	w.Close()
+	f.Close()
+
+	keyring, err := LoadKeyring(dir, dir+"/rpm")
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+
+	if len(keyring) != 1 || keyring[0].PrimaryKey.KeyId != key.PrimaryKey.KeyId {
+		t.Errorf(TMPL_MISMATCH, "keyring", key.PrimaryKey.KeyIdString(), keyring)
+	}
+
+	ioutil.WriteFile(dir+"/README", []byte("not a key"), 0644)
+	if _, err := LoadKeyring(dir, dir+"/rpm"); err == nil {
+		t.Errorf("Invalid key file must be rejected")
+	}
+}
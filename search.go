@@ -0,0 +1,445 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Orders of the search results
+const (
+	SortRelevance = "relevance"
+	SortName      = "name"
+	SortSize      = "size"
+	SortRepo      = "repo"
+)
+
+// Weights of the relevance model. A package gets the best name score of
+// every term, the summary score and the bonuses.
+const (
+	scoreExactName     = 100
+	scoreNamePrefix    = 50
+	scoreNameWord      = 30
+	scoreNameSubstring = 10
+	scoreSummaryWord   = 8
+	scoreSummaryHit    = 4
+	scoreInstalled     = 5
+	scoreSubpackage    = -20
+)
+
+// The suffixes of packages which are rarely the package the user looks for
+var subpackageSuffixes = []string{"-devel", "-debug", "-debuginfo", "-debugsource", "-static"}
+
+// SearchFields selects the package fields the search terms are matched
+// against in addition to the name.
+type SearchFields struct {
+	Summary     bool // from synthesis
+	Description bool // from info
+	URL         bool // from info
+	Group       bool // from synthesis
+}
+
+// NeedInfo reports whether the fields are loaded from info.xml.lzma.
+func (f SearchFields) NeedInfo() bool {
+	return f.Description || f.URL
+}
+
+// MatchMode is the way the query terms are matched against the fields.
+type MatchMode int
+
+const (
+	MatchSubstring MatchMode = iota // shell pattern anywhere in the field, like *term*
+	MatchExact                      // the whole field equals the term
+	MatchGlob                       // the whole field matches the shell pattern
+	MatchRegex                      // the field matches the regular expression
+)
+
+// The architectures recognized in the "name.arch" query syntax
+var queryArches = []string{"noarch", "src", "i386", "i486", "i586", "i686", "x86_64", "armv7hl", "aarch64"}
+
+// SearchQuery describes what Search looks for.
+type SearchQuery struct {
+	Terms    [][]string // every term with its alternative spellings, like libfoo and lib64foo
+	Mode     MatchMode
+	Fields   SearchFields
+	MatchAll bool // every term must match, otherwise any of them
+}
+
+// searchPattern is one spelling of a term. The arch and version come from
+// the "name.arch" and "name-version" syntax, such patterns are only
+// matched against the whole name.
+type searchPattern struct {
+	re      *regexp.Regexp
+	arch    string
+	version string
+}
+
+type searchTerm struct {
+	patterns []searchPattern
+}
+
+// globBody converts a shell pattern to a regular expression.
+func globBody(glob string) string {
+	res := ""
+	for i := 0; i < len(glob); i++ {
+		switch ch := glob[i]; ch {
+		case '*':
+			res += ".*"
+
+		case '?':
+			res += "."
+
+		case '[':
+			j := strings.IndexByte(glob[i+1:], ']')
+			if j < 0 {
+				res += `\[`
+				continue
+			}
+
+			class := glob[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			res += "[" + strings.Replace(class, `\`, `\\`, -1) + "]"
+			i += j + 1
+
+		default:
+			res += regexp.QuoteMeta(string(ch))
+		}
+	}
+	return res
+}
+
+// patternRegexp returns the case insensitive regular expression for the
+// term. Qualified terms match the whole name even in MatchSubstring mode.
+func patternRegexp(term string, mode MatchMode, qualified bool) string {
+	switch mode {
+	case MatchExact:
+		return "(?is)^" + regexp.QuoteMeta(term) + "$"
+
+	case MatchGlob:
+		return "(?is)^(?:" + globBody(term) + ")$"
+
+	case MatchRegex:
+		return "(?i)" + term
+	}
+
+	if qualified {
+		return "(?is)^(?:" + globBody(term) + ")$"
+	}
+	return "(?is)" + globBody(term)
+}
+
+type queryName struct {
+	name    string
+	arch    string
+	version string
+}
+
+// parseQueryName returns the possible meanings of the term: the plain
+// name, "name.arch", "name-version[-release]" and
+// "name-version[-release].arch".
+func parseQueryName(term string) []queryName {
+	res := []queryName{{name: term}}
+
+	if i := strings.LastIndex(term, "."); i > 0 {
+		for _, a := range queryArches {
+			if strings.EqualFold(term[i+1:], a) {
+				res = append(res, queryName{name: term[:i], arch: a})
+			}
+		}
+	}
+
+	for _, q := range append([]queryName{}, res...) {
+		for i := 1; i < len(q.name)-1; i++ {
+			if q.name[i] == '-' && q.name[i+1] >= '0' && q.name[i+1] <= '9' {
+				res = append(res, queryName{name: q.name[:i], arch: q.arch, version: q.name[i+1:]})
+			}
+		}
+	}
+	return res
+}
+
+func compileSearchTerms(terms [][]string, mode MatchMode) ([]searchTerm, error) {
+	res := []searchTerm{}
+	for _, alts := range terms {
+		term := searchTerm{}
+		for _, s := range alts {
+			if s == "" {
+				continue
+			}
+
+			names := []queryName{{name: s}}
+			if mode != MatchRegex {
+				names = parseQueryName(s)
+			}
+
+			for _, n := range names {
+				qualified := n.arch != "" || n.version != ""
+				re, err := regexp.Compile(patternRegexp(n.name, mode, qualified))
+				if err != nil {
+					return nil, fmt.Errorf("incorrect search term '%s': %v", s, err)
+				}
+				term.patterns = append(term.patterns, searchPattern{re, n.arch, n.version})
+			}
+		}
+
+		if len(term.patterns) > 0 {
+			res = append(res, term)
+		}
+	}
+	return res, nil
+}
+
+// matchVersion checks "version" or "version-release" with an optional
+// epoch against the package.
+func matchVersion(pkg Package, version string) bool {
+	for _, v := range []string{pkg.Version, pkg.FullVersion()} {
+		if v == version || strings.HasPrefix(v, version+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t searchTerm) match(pkg Package, fields SearchFields, arch []string) bool {
+	for _, p := range t.patterns {
+		if p.arch != "" && !strings.EqualFold(pkg.Arch, p.arch) {
+			continue
+		}
+
+		if p.arch == "" && len(arch) > 0 && !compareArch(arch, pkg) {
+			continue
+		}
+
+		if p.version != "" && !matchVersion(pkg, p.version) {
+			continue
+		}
+
+		if p.re.MatchString(pkg.Name) {
+			return true
+		}
+
+		if p.arch != "" || p.version != "" {
+			continue
+		}
+
+		switch {
+		case fields.Summary && p.re.MatchString(pkg.Summary):
+			return true
+		case fields.Description && p.re.MatchString(pkg.Description):
+			return true
+		case fields.URL && p.re.MatchString(pkg.URL):
+			return true
+		case fields.Group && p.re.MatchString(pkg.Group):
+			return true
+		}
+	}
+	return false
+}
+
+// matchSearchTerms checks the package against the terms. With matchAll
+// every term must match, otherwise any of them.
+func matchSearchTerms(terms []searchTerm, pkg Package, fields SearchFields, matchAll bool, arch []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+
+	for _, t := range terms {
+		ok := t.match(pkg, fields, arch)
+		if ok && !matchAll {
+			return true
+		}
+
+		if !ok && matchAll {
+			return false
+		}
+	}
+	return matchAll
+}
+
+// Search returns the packages matching the query. The architectures are
+// used for the terms without the ".arch" suffix. The Description and URL
+// fields need the cache loaded with the Info option.
+func (c Cache) Search(q SearchQuery, arch []string, onlyLast bool) (<-chan Package, error) {
+	compiled, err := compileSearchTerms(q.Terms, q.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, alts := range q.Terms {
+		names = append(names, alts...)
+	}
+
+	return c.search(names, nil, onlyLast, func(pkg Package) bool {
+		return matchSearchTerms(compiled, pkg, q.Fields, q.MatchAll, arch)
+	}), nil
+}
+
+// isWordStart reports whether a match at pos starts a word: "qt" starts a
+// word in "qt5-base" and "python-qt", but not in "lib64qt5core".
+func isWordStart(s string, pos int) bool {
+	if pos == 0 {
+		return true
+	}
+
+	prev := rune(s[pos-1])
+	return !unicode.IsLetter(prev) && !unicode.IsDigit(prev)
+}
+
+func (t searchTerm) nameScore(name string) int {
+	res := 0
+	for _, p := range t.patterns {
+		for _, loc := range p.re.FindAllStringIndex(name, -1) {
+			score := scoreNameSubstring
+			switch {
+			case loc[0] == 0 && loc[1] == len(name):
+				score = scoreExactName
+			case loc[0] == 0:
+				score = scoreNamePrefix
+			case isWordStart(name, loc[0]):
+				score = scoreNameWord
+			}
+
+			if score > res {
+				res = score
+			}
+		}
+	}
+	return res
+}
+
+func (t searchTerm) summaryScore(summary string) int {
+	res := 0
+	for _, p := range t.patterns {
+		if p.arch != "" || p.version != "" {
+			continue
+		}
+
+		for _, loc := range p.re.FindAllStringIndex(summary, -1) {
+			if isWordStart(summary, loc[0]) {
+				return scoreSummaryWord
+			}
+			res = scoreSummaryHit
+		}
+	}
+	return res
+}
+
+// searchScore returns the relevance of the package for the terms.
+func searchScore(terms []searchTerm, pkg Package) int {
+	res := 0
+	for _, t := range terms {
+		res += t.nameScore(pkg.Name)
+		res += t.summaryScore(pkg.Summary)
+	}
+
+	if pkg.IsInstalled() {
+		res += scoreInstalled
+	}
+
+	for _, s := range subpackageSuffixes {
+		if strings.HasSuffix(pkg.Name, s) {
+			res += scoreSubpackage
+			break
+		}
+	}
+	return res
+}
+
+type scoredPackage struct {
+	pkg   Package
+	score int
+}
+
+type packagesByScore []scoredPackage
+
+func (p packagesByScore) Len() int      { return len(p) }
+func (p packagesByScore) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p packagesByScore) Less(i, j int) bool {
+	if p[i].score != p[j].score {
+		return p[i].score > p[j].score
+	}
+
+	// Shorter names are closer to the query
+	if len(p[i].pkg.Name) != len(p[j].pkg.Name) {
+		return len(p[i].pkg.Name) < len(p[j].pkg.Name)
+	}
+	return p[i].pkg.Name < p[j].pkg.Name
+}
+
+type packagesBySize []Package
+
+func (p packagesBySize) Len() int      { return len(p) }
+func (p packagesBySize) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p packagesBySize) Less(i, j int) bool {
+	if p[i].RPMSize != p[j].RPMSize {
+		return p[i].RPMSize > p[j].RPMSize
+	}
+	return p[i].Name < p[j].Name
+}
+
+type packagesByRepo []Package
+
+func (p packagesByRepo) Len() int      { return len(p) }
+func (p packagesByRepo) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p packagesByRepo) Less(i, j int) bool {
+	if p[i].Repository != p[j].Repository {
+		return p[i].Repository < p[j].Repository
+	}
+	return p[i].Name < p[j].Name
+}
+
+// checkSortOrder returns an error if the search results can't be sorted
+// in the order.
+func checkSortOrder(order string) error {
+	switch order {
+	case SortRelevance, SortName, SortSize, SortRepo:
+		return nil
+	}
+	return fmt.Errorf("unknown sort order '%s', use %s, %s, %s or %s", order, SortRelevance, SortName, SortSize, SortRepo)
+}
+
+// SortSearchResults sorts the packages found by Search for the query:
+// by relevance (the most relevant first), name, size (the largest first)
+// or media.
+func SortSearchResults(pkgs Packages, q SearchQuery, order string) error {
+	switch order {
+	case SortRelevance:
+		compiled, err := compileSearchTerms(q.Terms, q.Mode)
+		if err != nil {
+			return err
+		}
+
+		scored := make(packagesByScore, len(pkgs))
+		for i, pkg := range pkgs {
+			scored[i] = scoredPackage{pkg, searchScore(compiled, pkg)}
+		}
+
+		sort.Stable(scored)
+		for i, s := range scored {
+			pkgs[i] = s.pkg
+		}
+
+	case SortName:
+		sort.Stable(pkgs)
+
+	case SortSize:
+		sort.Stable(packagesBySize(pkgs))
+
+	case SortRepo:
+		sort.Stable(packagesByRepo(pkgs))
+
+	default:
+		return checkSortOrder(order)
+	}
+	return nil
+}
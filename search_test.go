@@ -0,0 +1,271 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestPatternRegexp(t *testing.T) {
+	cases := []struct {
+		term   string
+		mode   MatchMode
+		text   string
+		expect bool
+	}{
+		{"pdf", MatchSubstring, "Tools for PDF signing", true},
+		{"p?f", MatchSubstring, "Tools for PDF signing", true},
+		{"pdf*sign", MatchSubstring, "Tools for PDF signing", true},
+		{"sign*pdf", MatchSubstring, "Tools for PDF signing", false},
+		{"lib[0-9]", MatchSubstring, "lib64foo", true},
+		{"lib[!0-9]", MatchSubstring, "lib64foo", false},
+		{"c++", MatchSubstring, "libstdc++", true},
+		{"example.com/foo", MatchSubstring, "http://example.com/foo", true},
+		{"a.c", MatchSubstring, "abc", false},
+		{"[abc", MatchSubstring, "x[abc", true},
+
+		{"python", MatchExact, "python", true},
+		{"python", MatchExact, "Python", true},
+		{"python", MatchExact, "python-lxml", false},
+		{"c++", MatchExact, "c++", true},
+
+		{"python-*", MatchGlob, "python-lxml", true},
+		{"python-*", MatchGlob, "python", false},
+		{"python", MatchGlob, "python-lxml", false},
+
+		{"^lib64qt5(core|gui)", MatchRegex, "lib64qt5core5", true},
+		{"^lib64qt5(core|gui)", MatchRegex, "lib64qt5network5", false},
+		{"qt5$", MatchRegex, "python-qt5", true},
+	}
+
+	for _, c := range cases {
+		re, err := regexp.Compile(patternRegexp(c.term, c.mode, false))
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.term)
+			continue
+		}
+
+		if res := re.MatchString(c.text); res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.term+" ~ "+c.text, c.expect, res)
+		}
+	}
+}
+
+func TestParseQueryName(t *testing.T) {
+	cases := []struct {
+		term   string
+		expect []queryName
+	}{
+		{"python", []queryName{{name: "python"}}},
+		{"python.x86_64", []queryName{{name: "python.x86_64"}, {name: "python", arch: "x86_64"}}},
+		{"python-3.4", []queryName{{name: "python-3.4"}, {name: "python", version: "3.4"}}},
+		{"python-lxml", []queryName{{name: "python-lxml"}}},
+		{"foo-2-1.5-2.i586", []queryName{
+			{name: "foo-2-1.5-2.i586"},
+			{name: "foo-2-1.5-2", arch: "i586"},
+			{name: "foo", version: "2-1.5-2.i586"},
+			{name: "foo-2", version: "1.5-2.i586"},
+			{name: "foo-2-1.5", version: "2.i586"},
+			{name: "foo", arch: "i586", version: "2-1.5-2"},
+			{name: "foo-2", arch: "i586", version: "1.5-2"},
+			{name: "foo-2-1.5", arch: "i586", version: "2"},
+		}},
+	}
+
+	for _, c := range cases {
+		if res := parseQueryName(c.term); !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.term, c.expect, res)
+		}
+	}
+}
+
+func TestCacheSearch(t *testing.T) {
+	cache := Cache{
+		packages: Packages{
+			{Name: "pdfsig", Arch: "x86_64", Version: "1.0-1", Summary: "Sign PDF documents", Group: "Office"},
+			{Name: "okular", Arch: "x86_64", Version: "1.0-1", Summary: "Document viewer",
+				Description: "Views PDF, PostScript and DjVu files.", URL: "http://okular.kde.org", Group: "Graphics"},
+			{Name: "lib64poppler", Arch: "x86_64", Version: "1.0-1", Summary: "PDF rendering library", Group: "System/Libraries"},
+			{Name: "gimp", Arch: "x86_64", Version: "1.0-1", Summary: "Image editor", Group: "Graphics"},
+		},
+	}
+	sort.Sort(cache.packages)
+
+	all := SearchFields{Summary: true, Description: true, URL: true, Group: true}
+
+	cases := []struct {
+		terms    [][]string
+		fields   SearchFields
+		matchAll bool
+		expect   []string
+	}{
+		{[][]string{{"pdf"}}, SearchFields{}, false, []string{"pdfsig"}},
+		{[][]string{{"pdf"}}, SearchFields{Summary: true}, false, []string{"lib64poppler", "pdfsig"}},
+		{[][]string{{"pdf"}}, SearchFields{Description: true}, false, []string{"okular", "pdfsig"}},
+		{[][]string{{"pdf"}}, all, false, []string{"lib64poppler", "okular", "pdfsig"}},
+		{[][]string{{"pdf"}, {"sign"}}, SearchFields{Summary: true}, true, []string{"pdfsig"}},
+		{[][]string{{"pdf"}, {"image"}}, SearchFields{Summary: true}, false, []string{"gimp", "lib64poppler", "pdfsig"}},
+		{[][]string{{"graphics"}, {"kde.org"}}, all, true, []string{"okular"}},
+		{[][]string{{"libpoppler", "lib64poppler"}, {"pdf"}}, SearchFields{Summary: true}, true, []string{"lib64poppler"}},
+		{[][]string{{""}}, all, false, []string{}},
+	}
+
+	for _, c := range cases {
+		q := SearchQuery{Terms: c.terms, Fields: c.fields, MatchAll: c.matchAll}
+		out, err := cache.Search(q, []string{"x86_64"}, true)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.terms)
+			continue
+		}
+
+		res := []string{}
+		for p := range out {
+			res = append(res, p.Name)
+		}
+		sort.Strings(res)
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.terms, c.expect, res)
+		}
+	}
+}
+
+func TestSearchModes(t *testing.T) {
+	cache := Cache{
+		packages: Packages{
+			{Name: "python", Arch: "x86_64", Version: "2.7.9-1", FileName: "python-2.7.9-1-rosa2014.1.x86_64"},
+			{Name: "python", Arch: "x86_64", Version: "2.7.3-2", FileName: "python-2.7.3-2-rosa2014.1.x86_64"},
+			{Name: "python", Arch: "i586", Version: "2.7.9-1", FileName: "python-2.7.9-1-rosa2014.1.i586"},
+			{Name: "python-lxml", Arch: "x86_64", Version: "3.4.1-1", FileName: "python-lxml-3.4.1-1-rosa2014.1.x86_64"},
+			{Name: "python3", Arch: "x86_64", Version: "3.4.2-1", FileName: "python3-3.4.2-1-rosa2014.1.x86_64"},
+			{Name: "lib64qt5core5", Arch: "x86_64", Version: "5.5.0-1", FileName: "lib64qt5core5-5.5.0-1-rosa2014.1.x86_64"},
+			{Name: "lib64qt5gui5", Arch: "x86_64", Version: "5.5.0-1", FileName: "lib64qt5gui5-5.5.0-1-rosa2014.1.x86_64"},
+			{Name: "lib64qt5network5", Arch: "x86_64", Version: "5.5.0-1", FileName: "lib64qt5network5-5.5.0-1-rosa2014.1.x86_64"},
+		},
+	}
+	sort.Sort(cache.packages)
+
+	cases := []struct {
+		term   string
+		mode   MatchMode
+		expect []string
+	}{
+		{"python", MatchSubstring, []string{
+			"python-2.7.3-2-rosa2014.1.x86_64", "python-2.7.9-1-rosa2014.1.x86_64",
+			"python-lxml-3.4.1-1-rosa2014.1.x86_64", "python3-3.4.2-1-rosa2014.1.x86_64"}},
+		{"python", MatchExact, []string{"python-2.7.3-2-rosa2014.1.x86_64", "python-2.7.9-1-rosa2014.1.x86_64"}},
+		{"python*", MatchGlob, []string{
+			"python-2.7.3-2-rosa2014.1.x86_64", "python-2.7.9-1-rosa2014.1.x86_64",
+			"python-lxml-3.4.1-1-rosa2014.1.x86_64", "python3-3.4.2-1-rosa2014.1.x86_64"}},
+		{"python?", MatchGlob, []string{"python3-3.4.2-1-rosa2014.1.x86_64"}},
+		{"^lib64qt5(core|gui)", MatchRegex, []string{"lib64qt5core5-5.5.0-1-rosa2014.1.x86_64", "lib64qt5gui5-5.5.0-1-rosa2014.1.x86_64"}},
+		{"python.i586", MatchSubstring, []string{"python-2.7.9-1-rosa2014.1.i586"}},
+		{"python-2.7.3", MatchSubstring, []string{"python-2.7.3-2-rosa2014.1.x86_64"}},
+		{"python-2.7.9-1.i586", MatchExact, []string{"python-2.7.9-1-rosa2014.1.i586"}},
+		{"python-lxml", MatchExact, []string{"python-lxml-3.4.1-1-rosa2014.1.x86_64"}},
+	}
+
+	for _, c := range cases {
+		q := SearchQuery{Terms: [][]string{{c.term}}, Mode: c.mode}
+		out, err := cache.Search(q, []string{"x86_64", "noarch"}, false)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.term)
+			continue
+		}
+
+		res := []string{}
+		for p := range out {
+			res = append(res, p.FileName)
+		}
+		sort.Strings(res)
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.term, c.expect, res)
+		}
+	}
+
+	if _, err := cache.Search(SearchQuery{Terms: [][]string{{"(core"}}, Mode: MatchRegex}, nil, false); err == nil {
+		t.Errorf("Incorrect regular expression must be rejected")
+	}
+}
+
+func TestSortSearchResults(t *testing.T) {
+	pkgs := Packages{
+		{Name: "lib64qt5core5", Summary: "Qt5 core library", RPMSize: 300, Repository: "main"},
+		{Name: "lib64qt5gui5", Summary: "Qt5 GUI library", RPMSize: 200, Repository: "main"},
+		{Name: "python-qt5", Summary: "Python bindings for Qt5", RPMSize: 100, Repository: "contrib"},
+		{Name: "qt5-base", Summary: "Qt5 base libraries", RPMSize: 50, Repository: "main"},
+		{Name: "qt5-base-devel", Summary: "Development files for Qt5", RPMSize: 400, Repository: "main"},
+		{Name: "qt", Summary: "Qt toolkit", RPMSize: 10, Repository: "updates"},
+		{Name: "libqtcurve", Summary: "Qt widget style", RPMSize: 20, Repository: "contrib",
+			Installed: []NEVRA{{Name: "libqtcurve"}}},
+	}
+
+	cases := []struct {
+		order  string
+		terms  [][]string
+		expect []string
+	}{
+		{
+			SortRelevance, [][]string{{"qt"}},
+			[]string{"qt", "qt5-base", "python-qt5", "qt5-base-devel", "libqtcurve", "lib64qt5gui5", "lib64qt5core5"},
+		},
+
+		{
+			SortRelevance, [][]string{{"qt5"}, {"python"}},
+			[]string{"python-qt5", "qt5-base", "qt5-base-devel", "lib64qt5gui5", "lib64qt5core5", "libqtcurve", "qt"},
+		},
+
+		{
+			SortName, [][]string{{"qt"}},
+			[]string{"lib64qt5core5", "lib64qt5gui5", "libqtcurve", "python-qt5", "qt", "qt5-base", "qt5-base-devel"},
+		},
+
+		{
+			SortSize, [][]string{{"qt"}},
+			[]string{"qt5-base-devel", "lib64qt5core5", "lib64qt5gui5", "python-qt5", "qt5-base", "libqtcurve", "qt"},
+		},
+
+		{
+			SortRepo, [][]string{{"qt"}},
+			[]string{"libqtcurve", "python-qt5", "lib64qt5core5", "lib64qt5gui5", "qt5-base", "qt5-base-devel", "qt"},
+		},
+	}
+
+	for _, c := range cases {
+		sorted := append(Packages{}, pkgs...)
+		if err := SortSearchResults(sorted, SearchQuery{Terms: c.terms}, c.order); err != nil {
+			t.Errorf(TMPL_ERROR, err, c.order)
+			continue
+		}
+
+		res := []string{}
+		for _, p := range sorted {
+			res = append(res, p.Name)
+		}
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.order, c.expect, res)
+		}
+	}
+
+	if err := SortSearchResults(pkgs, SearchQuery{}, "date"); err == nil {
+		t.Errorf("Unknown sort order must be rejected")
+	}
+
+	for _, order := range []string{SortRelevance, SortName, SortSize, SortRepo} {
+		if err := checkSortOrder(order); err != nil {
+			t.Errorf(TMPL_ERROR, err, order)
+		}
+	}
+
+	if err := checkSortOrder("date"); err == nil {
+		t.Errorf("Unknown sort order must be rejected")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Only this many names sharing the most trigrams with the query are
+// compared by the edit distance.
+const suggestCandidates = 200
+
+// nameIndex is a trigram index of the package names. It finds the names
+// similar to a misspelled one without comparing it with every name.
+type nameIndex struct {
+	names    []string
+	trigrams map[string][]int // indexes in names
+}
+
+// lazyNameIndex builds the index on the first use, most commands never
+// need it.
+type lazyNameIndex struct {
+	once  sync.Once
+	index *nameIndex
+}
+
+// trigrams returns the three letter substrings of the name. The name is
+// padded, so the first and last letters have their own trigrams.
+func trigrams(name string) []string {
+	s := "  " + strings.ToLower(name) + " "
+	res := []string{}
+	seen := map[string]bool{}
+	for i := 0; i+3 <= len(s); i++ {
+		t := s[i : i+3]
+		if !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+func newNameIndex(pkgs Packages) *nameIndex {
+	idx := &nameIndex{trigrams: map[string][]int{}}
+
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		if seen[pkg.Name] {
+			continue
+		}
+		seen[pkg.Name] = true
+
+		n := len(idx.names)
+		idx.names = append(idx.names, pkg.Name)
+		for _, t := range trigrams(pkg.Name) {
+			idx.trigrams[t] = append(idx.trigrams[t], n)
+		}
+	}
+	return idx
+}
+
+// editDistance returns the number of inserted, deleted, replaced and
+// transposed letters to get b from a.
+func editDistance(a, b string) int {
+	ra := []rune(strings.ToLower(a))
+	rb := []rune(strings.ToLower(b))
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// nameDistance compares the names ignoring the lib/lib64 difference, so
+// libfoo1 is close to lib64foo1.
+func nameDistance(query, name string) int {
+	res := editDistance(query, name)
+	for _, q := range libNameVariants(query, "") {
+		if d := editDistance(q, name); d < res {
+			res = d
+		}
+	}
+	return res
+}
+
+type suggestion struct {
+	name     string
+	shared   int
+	distance int
+}
+
+type suggestionsByShared []suggestion
+
+func (s suggestionsByShared) Len() int      { return len(s) }
+func (s suggestionsByShared) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s suggestionsByShared) Less(i, j int) bool {
+	if s[i].shared != s[j].shared {
+		return s[i].shared > s[j].shared
+	}
+	return s[i].name < s[j].name
+}
+
+type suggestionsByDistance []suggestion
+
+func (s suggestionsByDistance) Len() int      { return len(s) }
+func (s suggestionsByDistance) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s suggestionsByDistance) Less(i, j int) bool {
+	if s[i].distance != s[j].distance {
+		return s[i].distance < s[j].distance
+	}
+	return suggestionsByShared(s).Less(i, j)
+}
+
+// Suggest returns up to max names close to the query, the closest first.
+func (idx *nameIndex) Suggest(query string, max int) []string {
+	shared := map[int]int{}
+	for _, q := range libNameVariants(query, "") {
+		for _, t := range trigrams(q) {
+			for _, n := range idx.trigrams[t] {
+				shared[n]++
+			}
+		}
+	}
+
+	candidates := suggestionsByShared{}
+	for n, count := range shared {
+		candidates = append(candidates, suggestion{name: idx.names[n], shared: count})
+	}
+	sort.Sort(candidates)
+
+	if len(candidates) > suggestCandidates {
+		candidates = candidates[:suggestCandidates]
+	}
+
+	// Allow about one typo per four letters
+	limit := 1 + len(query)/4
+
+	res := suggestionsByDistance{}
+	for _, c := range candidates {
+		if strings.EqualFold(c.name, query) {
+			continue
+		}
+
+		c.distance = nameDistance(query, c.name)
+		if c.distance <= limit {
+			res = append(res, c)
+		}
+	}
+	sort.Sort(res)
+
+	names := []string{}
+	for i := 0; i < len(res) && i < max; i++ {
+		names = append(names, res[i].name)
+	}
+	return names
+}
+
+// Suggest returns up to max package names close to the misspelled name.
+func (c Cache) Suggest(name string, max int) []string {
+	if c.names == nil {
+		return newNameIndex(c.packages).Suggest(name, max)
+	}
+
+	c.names.once.Do(func() {
+		c.names.index = newNameIndex(c.packages)
+	})
+	return c.names.index.Suggest(name, max)
+}
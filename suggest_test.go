@@ -0,0 +1,67 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect int
+	}{
+		{"", "", 0},
+		{"vim", "vim", 0},
+		{"vim", "VIM", 0},
+		{"", "vim", 3},
+		{"libreoffice-writter", "libreoffice-writer", 1},
+		{"fierfox", "firefox", 1},
+		{"kitten", "sitting", 3},
+		{"gimp", "gmip", 1},
+	}
+
+	for _, c := range cases {
+		if res := editDistance(c.a, c.b); res != c.expect {
+			t.Errorf(TMPL_MISMATCH, c.a+" -> "+c.b, c.expect, res)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	cache := Cache{
+		packages: Packages{
+			{Name: "libreoffice-writer"},
+			{Name: "libreoffice-calc"},
+			{Name: "libreoffice-common"},
+			{Name: "lib64qt5core5"},
+			{Name: "lib64qt5gui5"},
+			{Name: "firefox"},
+			{Name: "firefox"},
+			{Name: "vim"},
+			{Name: "vim-enhanced"},
+		},
+	}
+
+	cases := []struct {
+		query  string
+		expect []string
+	}{
+		{"libreoffice-writter", []string{"libreoffice-writer"}},
+		{"fierfox", []string{"firefox"}},
+		{"libqt5core5", []string{"lib64qt5core5"}},
+		{"lib64qt5core", []string{"lib64qt5core5", "lib64qt5gui5"}},
+		{"vim", []string{}},
+		{"emacs", []string{}},
+	}
+
+	for _, c := range cases {
+		if res := cache.Suggest(c.query, 5); !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.query, c.expect, res)
+		}
+	}
+}
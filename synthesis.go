@@ -32,6 +32,74 @@ func splitLine(line string, out ...*string) error {
 	return nil
 }
 
+// parseDependency parses a single dependency item from a synthesis line.
+// The item has the form "name", "name[*]", "name[== evr]" or
+// "name[*][>= evr]", where "[*]" marks a pre-requirement.
+func parseDependency(item string) (Dependency, error) {
+	dep := Dependency{}
+
+	n := strings.Index(item, "[")
+	if n < 0 {
+		dep.Name = item
+		return dep, nil
+	}
+
+	dep.Name = item[:n]
+	rest := strings.TrimPrefix(item[n:], "[*]")
+	if rest == "" {
+		return dep, nil
+	}
+
+	if !strings.HasPrefix(rest, "[") || !strings.HasSuffix(rest, "]") {
+		return dep, fmt.Errorf("Can't parse dependency '%v'", item)
+	}
+
+	rest = strings.TrimSpace(rest[1 : len(rest)-1])
+	if rest == "*" {
+		return dep, nil
+	}
+
+	n = strings.IndexAny(rest, " \t")
+	if n < 0 {
+		return dep, fmt.Errorf("Can't parse dependency '%v'", item)
+	}
+
+	dep.Flags = rest[:n]
+	dep.EVR = strings.TrimSpace(rest[n+1:])
+
+	switch dep.Flags {
+	case "==", "<", "<=", ">", ">=":
+	default:
+		return dep, fmt.Errorf("Can't parse dependency '%v': unknown operator '%v'", item, dep.Flags)
+	}
+
+	return dep, nil
+}
+
+// parseDependencies parses @provides@, @requires@, @conflicts@,
+// @obsoletes@ and @suggests@ lines.
+func parseDependencies(line string) ([]Dependency, error) {
+	items := strings.Split(line, "@")
+	if len(items) < 3 {
+		return nil, nil
+	}
+
+	res := make([]Dependency, 0, len(items)-2)
+	for _, item := range items[2:] {
+		if item == "" {
+			continue
+		}
+
+		dep, err := parseDependency(item)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, dep)
+	}
+
+	return res, nil
+}
+
 func ReadSynthesisFile(repo Repository, file string, out chan<- Package) error {
 	f, err := os.Open(file)
 	if err != nil {
@@ -48,13 +116,14 @@ func ReadSynthesisFile(repo Repository, file string, out chan<- Package) error {
 	r := bufio.NewReader(gz)
 
 	cur := Package{}
-	for err == nil {
+	for {
+		// The last line may have no line break
 		line, err := r.ReadString('\n')
-		if err == io.EOF {
+		if err == io.EOF && line == "" {
 			break
 		}
 
-		if err != nil {
+		if err != nil && err != io.EOF {
 			return fmt.Errorf("can't read synthesis file: %v", err)
 		}
 
@@ -67,11 +136,11 @@ func ReadSynthesisFile(repo Repository, file string, out chan<- Package) error {
 		// @info@libvo-amrwbenc0-0.1.2-1-rosa2014.1.i586@0@143156@System/Libraries@rosa@2014.1
 
 		if strings.HasPrefix(line, "@info") {
-			var size string
+			var epoch, size string
 
 			err = splitLine(line,
 				&cur.FileName,
-				nil,
+				&epoch,
 				&size,
 				&cur.Group,
 				&cur.Disttag,
@@ -82,6 +151,11 @@ func ReadSynthesisFile(repo Repository, file string, out chan<- Package) error {
 
 			cur.Repository = repo.Name
 
+			cur.Epoch, err = strconv.Atoi(epoch)
+			if err != nil {
+				return fmt.Errorf("Can't read synthesis file: incorrect epoch '%v': %v", epoch, err)
+			}
+
 			cur.Size, err = strconv.Atoi(size)
 			if err != nil {
 				return fmt.Errorf("Can't read synthesis file: incorrect filesize '%v': %v", size, err)
@@ -104,6 +178,28 @@ func ReadSynthesisFile(repo Repository, file string, out chan<- Package) error {
 			continue
 		}
 
+		var deps *[]Dependency
+		switch {
+		case strings.HasPrefix(line, "@provides@"):
+			deps = &cur.Provides
+		case strings.HasPrefix(line, "@requires@"):
+			deps = &cur.Requires
+		case strings.HasPrefix(line, "@conflicts@"):
+			deps = &cur.Conflicts
+		case strings.HasPrefix(line, "@obsoletes@"):
+			deps = &cur.Obsoletes
+		case strings.HasPrefix(line, "@suggests@"):
+			deps = &cur.Suggests
+		}
+
+		if deps != nil {
+			*deps, err = parseDependencies(line)
+			if err != nil {
+				return fmt.Errorf("Can't read synthesis file: %v", err)
+			}
+			continue
+		}
+
 		if strings.HasPrefix(line, "@summary@") {
 			cur.Summary = line[9:]
 			continue
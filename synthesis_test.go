@@ -0,0 +1,133 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseDependencies(t *testing.T) {
+	cases := []struct {
+		line   string
+		expect []Dependency
+	}{
+		{
+			"@provides@",
+			[]Dependency{},
+		},
+
+		{
+			"@requires@libc.so.6@libc.so.6(GLIBC_2.0)",
+			[]Dependency{
+				{Name: "libc.so.6"},
+				{Name: "libc.so.6(GLIBC_2.0)"},
+			},
+		},
+
+		{
+			"@provides@libvo-amrwbenc.so.0@libvo-amrwbenc0[== 0.1.2-1:2014.1]",
+			[]Dependency{
+				{Name: "libvo-amrwbenc.so.0"},
+				{Name: "libvo-amrwbenc0", Flags: "==", EVR: "0.1.2-1:2014.1"},
+			},
+		},
+
+		{
+			"@requires@rpm-helper[*][>= 0.24.8-1]@/bin/sh[*]@perl(Foo::Bar)[>= 1.2]",
+			[]Dependency{
+				{Name: "rpm-helper", Flags: ">=", EVR: "0.24.8-1"},
+				{Name: "/bin/sh"},
+				{Name: "perl(Foo::Bar)", Flags: ">=", EVR: "1.2"},
+			},
+		},
+
+		{
+			"@obsoletes@boomaga-qt4[< 1:0.7.0]@boomaga-old[*]",
+			[]Dependency{
+				{Name: "boomaga-qt4", Flags: "<", EVR: "1:0.7.0"},
+				{Name: "boomaga-old"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		res, err := parseDependencies(c.line)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, c.line)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.line, c.expect, res)
+		}
+	}
+
+	if _, err := parseDependencies("@conflicts@foo[~= 1.0]"); err == nil {
+		t.Errorf("Unknown operator must be rejected")
+	}
+}
+
+func TestReadSynthesisFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		data   string
+		expect []string
+		ok     bool
+	}{
+		{
+			// No line break after the last line
+			"@summary@Foo\n@info@foo-1.0-1-rosa2014.1.x86_64@0@100@System@rosa@2014.1\n" +
+				"@summary@Bar\n@info@bar-1.0-1-rosa2014.1.x86_64@0@100@System@rosa@2014.1",
+			[]string{"foo", "bar"},
+			true,
+		},
+
+		{
+			"@summary@Foo\n@info@foo-1.0-1-rosa2014.1.x86_64@0@big@System@rosa@2014.1\n",
+			nil,
+			false,
+		},
+	}
+
+	file := dir + "/synthesis.hdlist.cz"
+	for _, c := range cases {
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatal("Can't ceate synthesis file:", err)
+		}
+		gz := gzip.NewWriter(f)
+		gz.Write([]byte(c.data))
+		gz.Close()
+		f.Close()
+
+		out := make(chan Package, 10)
+		err = ReadSynthesisFile(Repository{Name: "Test"}, file, out)
+		close(out)
+
+		if (err == nil) != c.ok {
+			t.Errorf(TMPL_ERROR, err, c.data)
+			continue
+		}
+
+		res := []string{}
+		for p := range out {
+			res = append(res, p.Name)
+		}
+
+		if c.ok && !reflect.DeepEqual(res, c.expect) {
+			t.Errorf(TMPL_MISMATCH, c.data, c.expect, res)
+		}
+	}
+}
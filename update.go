@@ -0,0 +1,329 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Metadata files of a media. Only the synthesis is mandatory.
+var mediaInfoFiles = []struct {
+	name     string
+	required bool
+}{
+	{"synthesis.hdlist.cz", true},
+	{"info.xml.lzma", false},
+	{"files.xml.lzma", false},
+	{"changelog.xml.lzma", false},
+}
+
+// ParseMD5SUM parses the "md5  filename" lines of a MD5SUM file.
+func ParseMD5SUM(r io.Reader) (map[string]string, error) {
+	res := map[string]string{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != 32 {
+			return nil, fmt.Errorf("incorrect MD5SUM line '%s'", line)
+		}
+
+		res[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+
+	return res, s.Err()
+}
+
+// fileMd5 returns the MD5 of the file or an empty string if it can't be read.
+func fileMd5(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// localSums returns the checksums from the MD5SUM file in rep.Dir.
+func (rep Repository) localSums() (map[string]string, error) {
+	f, err := os.Open(rep.Dir + "/MD5SUM")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums, err := ParseMD5SUM(f)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse %s: %v", rep.Dir+"/MD5SUM", err)
+	}
+	return sums, nil
+}
+
+// Verify checks the metadata files in rep.Dir against the local MD5SUM.
+// Without arguments all known metadata files are checked; missing
+// optional files are not an error. The list of problems is returned.
+func (rep Repository) Verify(files ...string) []error {
+	sums, err := rep.localSums()
+	if os.IsNotExist(err) {
+		return []error{fmt.Errorf("MD5SUM is missing")}
+	}
+	if err != nil {
+		return []error{err}
+	}
+
+	if len(files) == 0 {
+		for _, f := range mediaInfoFiles {
+			if f.required || fileExists(rep.Dir+"/"+f.name) {
+				files = append(files, f.name)
+			}
+		}
+	}
+
+	res := []error{}
+	for _, name := range files {
+		sum, ok := sums[name]
+		if !ok {
+			res = append(res, fmt.Errorf("%s is not listed in MD5SUM", name))
+			continue
+		}
+
+		if !fileExists(rep.Dir + "/" + name) {
+			res = append(res, fmt.Errorf("%s is missing", name))
+			continue
+		}
+
+		if fileMd5(rep.Dir+"/"+name) != sum {
+			res = append(res, fmt.Errorf("%s is corrupted or truncated", name))
+		}
+	}
+
+	return res
+}
+
+// mediaInfoURL returns the URL of a file in the media_info directory.
+func (rep Repository) mediaInfoURL(baseURL string, file string) string {
+	dir := rep.MediaInfoDir
+	if dir == "" {
+		dir = "media_info"
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.Trim(dir, "/") + "/" + file
+}
+
+// UpdateRepository refreshes the metadata of the repository in rep.Dir.
+// Only files whose MD5 differs from the remote MD5SUM are downloaded. The
+// new files are checked and the whole media directory is replaced (not
+// atomically, see replaceDir) only when all of them have been fetched. If
+// a mirror fails, the next one is tried. The names of the updated files
+// are returned.
+func UpdateRepository(rep Repository) ([]string, error) {
+	// rep.Dir is VarDir/Name, a bad name from urpmi.cfg would point it
+	// outside of VarDir
+	if !validMediaName(rep.Name) {
+		return nil, fmt.Errorf("Incorrect media name '%s'", rep.Name)
+	}
+
+	var updated []string
+	_, err := rep.WithMirrors(func(baseURL string) error {
+		var err error
+		updated, err = updateRepositoryFrom(rep, baseURL)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func updateRepositoryFrom(rep Repository, baseURL string) ([]string, error) {
+	r, err := OpenURL(rep.mediaInfoURL(baseURL, "MD5SUM"))
+	if err != nil {
+		return nil, err
+	}
+
+	md5sum, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Can't download %s: %v", rep.mediaInfoURL(baseURL, "MD5SUM"), err)
+	}
+
+	sums, err := ParseMD5SUM(bytes.NewReader(md5sum))
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse %s: %v", rep.mediaInfoURL(baseURL, "MD5SUM"), err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(rep.Dir), 0755); err != nil {
+		return nil, err
+	}
+
+	// The new metadata is prepared in a directory next to rep.Dir which
+	// then replaces rep.Dir as a whole.
+	tmpDir, err := ioutil.TempDir(filepath.Dir(rep.Dir), "."+filepath.Base(rep.Dir)+".update-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// The files which are not metadata are kept as is
+	if err := linkOtherFiles(rep.Dir, tmpDir); err != nil {
+		return nil, err
+	}
+
+	// The optional files which aren't listed in MD5SUM are not copied, so
+	// no stale files are left from the previous version of the media.
+	updated := []string{}
+	for _, f := range mediaInfoFiles {
+		sum, ok := sums[f.name]
+		if !ok {
+			if f.required {
+				return nil, fmt.Errorf("Can't update %s: %s is not listed in MD5SUM", rep.Name, f.name)
+			}
+			continue
+		}
+
+		if fileMd5(rep.Dir+"/"+f.name) == sum {
+			if err := os.Link(rep.Dir+"/"+f.name, tmpDir+"/"+f.name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := downloadFile(rep.mediaInfoURL(baseURL, f.name), tmpDir+"/"+f.name, sum); err != nil {
+			return nil, err
+		}
+		updated = append(updated, f.name)
+	}
+
+	if err := ioutil.WriteFile(tmpDir+"/MD5SUM", md5sum, 0644); err != nil {
+		return nil, err
+	}
+
+	// The MD5SUM modification time is shown as the last update
+	now := time.Now()
+	os.Chtimes(tmpDir+"/MD5SUM", now, now)
+
+	if err := replaceDir(rep.Dir, tmpDir); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// linkOtherFiles hard links the regular files of dir which are not media
+// metadata into newDir.
+func linkOtherFiles(dir string, newDir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !f.Mode().IsRegular() || isMediaInfoFile(f.Name()) {
+			continue
+		}
+
+		if err := os.Link(dir+"/"+f.Name(), newDir+"/"+f.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isMediaInfoFile(name string) bool {
+	if name == "MD5SUM" {
+		return true
+	}
+
+	for _, f := range mediaInfoFiles {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceDir puts newDir in place of dir. The old directory is moved
+// aside first and restored if newDir can't be moved into its place.
+//
+// The swap is not atomic: between the two renames dir doesn't exist, so a
+// concurrent reader may see the media as missing. The files are never
+// mixed though, dir holds either the old or the new set.
+func replaceDir(dir string, newDir string) error {
+	old := newDir + ".old"
+	moved := true
+	if err := os.Rename(dir, old); os.IsNotExist(err) {
+		moved = false
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.Rename(newDir, dir); err != nil {
+		if !moved {
+			return err
+		}
+
+		if rerr := os.Rename(old, dir); rerr != nil {
+			return fmt.Errorf("%v; can't restore %s from %s: %v", err, dir, old, rerr)
+		}
+		return err
+	}
+
+	os.RemoveAll(old)
+	return nil
+}
+
+// downloadFile downloads url to file and checks its MD5.
+func downloadFile(url string, file string, sum string) error {
+	r, err := OpenURL(url)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return fmt.Errorf("Can't download %s: %v", url, err)
+	}
+
+	if res := hex.EncodeToString(h.Sum(nil)); res != sum {
+		return fmt.Errorf("Can't download %s: MD5 mismatch: expected %s, got %s", url, sum, res)
+	}
+
+	return f.Close()
+}
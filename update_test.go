@@ -0,0 +1,226 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type testMedia struct {
+	files    map[string]string
+	requests []string
+}
+
+func (m *testMedia) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/media/media_info/"):]
+	m.requests = append(m.requests, name)
+
+	if name == "MD5SUM" {
+		names := []string{}
+		for n := range m.files {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		for _, n := range names {
+			fmt.Fprintf(w, "%x  %s\n", md5.Sum([]byte(m.files[n])), n)
+		}
+		return
+	}
+
+	data, ok := m.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(data))
+}
+
+func TestUpdateRepository(t *testing.T) {
+	media := &testMedia{files: map[string]string{
+		"synthesis.hdlist.cz": "synthesis",
+		"info.xml.lzma":       "info",
+		"files.xml.lzma":      "files",
+	}}
+
+	srv := httptest.NewServer(media)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rep := Repository{Name: "main", URL: srv.URL + "/media/", Dir: dir + "/main"}
+
+	cases := []struct {
+		change   func()
+		updated  []string
+		requests []string
+	}{
+		{
+			func() {},
+			[]string{"synthesis.hdlist.cz", "info.xml.lzma", "files.xml.lzma"},
+			[]string{"MD5SUM", "synthesis.hdlist.cz", "info.xml.lzma", "files.xml.lzma"},
+		},
+
+		{
+			func() {},
+			[]string{},
+			[]string{"MD5SUM"},
+		},
+
+		{
+			func() { media.files["info.xml.lzma"] = "new info" },
+			[]string{"info.xml.lzma"},
+			[]string{"MD5SUM", "info.xml.lzma"},
+		},
+
+		// The file removed from the media is removed locally too
+		{
+			func() { delete(media.files, "files.xml.lzma") },
+			[]string{},
+			[]string{"MD5SUM"},
+		},
+	}
+
+	for i, c := range cases {
+		c.change()
+		media.requests = nil
+
+		updated, err := UpdateRepository(rep)
+		if err != nil {
+			t.Errorf(TMPL_ERROR, err, i)
+			continue
+		}
+
+		if !reflect.DeepEqual(updated, c.updated) {
+			t.Errorf(TMPL_MISMATCH, i, c.updated, updated)
+		}
+
+		if !reflect.DeepEqual(media.requests, c.requests) {
+			t.Errorf(TMPL_MISMATCH, i, c.requests, media.requests)
+		}
+
+		for name, data := range media.files {
+			if res, _ := ioutil.ReadFile(rep.Dir + "/" + name); string(res) != data {
+				t.Errorf(TMPL_MISMATCH, name, data, string(res))
+			}
+		}
+
+		if errs := rep.Verify(); len(errs) > 0 {
+			t.Errorf(TMPL_ERROR, errs, i)
+		}
+	}
+
+	if fileExists(rep.Dir + "/files.xml.lzma") {
+		t.Errorf("files.xml.lzma must be removed")
+	}
+
+	// A corrupted download must not replace the current files
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/media/media_info/synthesis.hdlist.cz" {
+			w.Write([]byte("corrupted"))
+			return
+		}
+
+		media.files["synthesis.hdlist.cz"] = "new synthesis"
+		media.ServeHTTP(w, r)
+	})
+
+	if _, err := UpdateRepository(rep); err == nil {
+		t.Errorf("Corrupted file must be rejected")
+	}
+
+	if res, _ := ioutil.ReadFile(rep.Dir + "/synthesis.hdlist.cz"); string(res) != "synthesis" {
+		t.Errorf(TMPL_MISMATCH, "synthesis.hdlist.cz", "synthesis", string(res))
+	}
+
+	if files, _ := ioutil.ReadDir(rep.Dir); len(files) != 3 {
+		t.Errorf(TMPL_MISMATCH, "files in media dir", 3, len(files))
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf(TMPL_MISMATCH, "media dirs", 1, len(files))
+	}
+}
+
+func TestReplaceDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(dir+"/media", 0755)
+	ioutil.WriteFile(dir+"/media/MD5SUM", []byte("old"), 0644)
+
+	// The old directory is restored if the new one can't be moved
+	if err := replaceDir(dir+"/media", dir+"/missing"); err == nil {
+		t.Errorf("Missing directory must be rejected")
+	}
+
+	if res, _ := ioutil.ReadFile(dir + "/media/MD5SUM"); string(res) != "old" {
+		t.Errorf(TMPL_MISMATCH, "MD5SUM", "old", string(res))
+	}
+
+	os.Mkdir(dir+"/new", 0755)
+	ioutil.WriteFile(dir+"/new/MD5SUM", []byte("new"), 0644)
+
+	if err := replaceDir(dir+"/media", dir+"/new"); err != nil {
+		t.Fatalf(TMPL_ERROR, err, dir)
+	}
+
+	if res, _ := ioutil.ReadFile(dir + "/media/MD5SUM"); string(res) != "new" {
+		t.Errorf(TMPL_MISMATCH, "MD5SUM", "new", string(res))
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf(TMPL_MISMATCH, "dirs", 1, len(files))
+	}
+}
+
+func TestRepositoryVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrpm_test")
+	if err != nil {
+		t.Fatal("Can't ceate tmp dir:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rep := Repository{Name: "main", Dir: dir}
+
+	if errs := rep.Verify(); len(errs) != 1 {
+		t.Errorf(TMPL_MISMATCH, "no MD5SUM", 1, errs)
+	}
+
+	ioutil.WriteFile(dir+"/synthesis.hdlist.cz", []byte("synthesis"), 0644)
+	ioutil.WriteFile(dir+"/info.xml.lzma", []byte("info"), 0644)
+	ioutil.WriteFile(dir+"/MD5SUM", []byte(fmt.Sprintf("%x  synthesis.hdlist.cz\n%x  info.xml.lzma\n%x  files.xml.lzma\n",
+		md5.Sum([]byte("synthesis")), md5.Sum([]byte("info")), md5.Sum([]byte("files")))), 0644)
+
+	if errs := rep.Verify(); len(errs) != 0 {
+		t.Errorf(TMPL_MISMATCH, "valid media", 0, errs)
+	}
+
+	if errs := rep.Verify("files.xml.lzma"); len(errs) != 1 {
+		t.Errorf(TMPL_MISMATCH, "missing file", 1, errs)
+	}
+
+	ioutil.WriteFile(dir+"/synthesis.hdlist.cz", []byte("synth"), 0644)
+	errs := rep.Verify()
+	if len(errs) != 1 || errs[0].Error() != "synthesis.hdlist.cz is corrupted or truncated" {
+		t.Errorf(TMPL_MISMATCH, "truncated file", "synthesis.hdlist.cz is corrupted or truncated", errs)
+	}
+}
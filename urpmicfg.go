@@ -0,0 +1,433 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// UrpmiCfg is a parsed urpmi.cfg file. The file is kept as a sequence of
+// raw lines grouped into blocks, so unmodified parts are written back
+// byte for byte, including comments, blank lines and ordering.
+type UrpmiCfg struct {
+	nodes []interface{} // *cfgLine for text outside blocks or *CfgBlock
+}
+
+// CfgBlock is a "{ ... }" block of urpmi.cfg: either the global options
+// block or a media.
+type CfgBlock struct {
+	Global bool
+	Name   string
+	URL    string
+
+	header string
+	footer string
+	dirty  bool
+	lines  []*cfgLine
+}
+
+// cfgLine is a single line. Key is empty for blank lines and comments.
+type cfgLine struct {
+	raw      string
+	key      string
+	value    string
+	hasValue bool
+}
+
+// ParseUrpmiCfg parses urpmi.cfg in the same line-oriented way as urpmi.
+func ParseUrpmiCfg(r io.Reader) (*UrpmiCfg, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &UrpmiCfg{}
+	var block *CfgBlock
+
+	for n, raw := range strings.Split(string(data), "\n") {
+		line := strings.TrimSpace(raw)
+
+		// Comments and blank lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			if block != nil {
+				block.lines = append(block.lines, &cfgLine{raw: raw})
+			} else {
+				cfg.nodes = append(cfg.nodes, &cfgLine{raw: raw})
+			}
+			continue
+		}
+
+		// End of block
+		if line == "}" {
+			if block == nil {
+				return nil, fmt.Errorf("line %d: unexpected '}'", n+1)
+			}
+			block.footer = raw
+			block = nil
+			continue
+		}
+
+		// Start of block
+		if strings.HasSuffix(line, "{") && !strings.HasSuffix(line, "\\{") {
+			if block != nil {
+				return nil, fmt.Errorf("line %d: unexpected '{'", n+1)
+			}
+
+			block = &CfgBlock{header: raw}
+			words := splitCfgWords(strings.TrimSuffix(line, "{"))
+			switch len(words) {
+			case 0:
+				block.Global = true
+			case 1:
+				block.Name = words[0]
+			case 2:
+				block.Name, block.URL = words[0], words[1]
+			default:
+				return nil, fmt.Errorf("line %d: incorrect media line '%s'", n+1, line)
+			}
+
+			cfg.nodes = append(cfg.nodes, block)
+			continue
+		}
+
+		if block == nil {
+			return nil, fmt.Errorf("line %d: option '%s' outside of a media block", n+1, line)
+		}
+
+		l := &cfgLine{raw: raw, key: line}
+		if i := strings.Index(line, ":"); i > 0 {
+			l.key = strings.TrimSpace(line[:i])
+			l.value = strings.TrimSpace(line[i+1:])
+			l.hasValue = true
+		}
+		block.lines = append(block.lines, l)
+	}
+
+	if block != nil {
+		return nil, fmt.Errorf("media '%s' is not closed", block.Name)
+	}
+
+	return cfg, nil
+}
+
+// ReadUrpmiCfg reads and parses the urpmi.cfg file.
+func ReadUrpmiCfg(file string) (*UrpmiCfg, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := ParseUrpmiCfg(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return cfg, nil
+}
+
+// Write writes the configuration. Unmodified lines are written as they
+// were read.
+func (cfg *UrpmiCfg) Write(w io.Writer) error {
+	lines := []string{}
+	for _, node := range cfg.nodes {
+		switch n := node.(type) {
+		case *cfgLine:
+			lines = append(lines, n.raw)
+
+		case *CfgBlock:
+			lines = append(lines, n.text()...)
+		}
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// Save atomically replaces the file with the configuration.
+func (cfg *UrpmiCfg) Save(file string) error {
+	buf := &bytes.Buffer{}
+	if err := cfg.Write(buf); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".urpmi.cfg-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), file)
+}
+
+// Global returns the global options block or nil.
+func (cfg *UrpmiCfg) Global() *CfgBlock {
+	for _, node := range cfg.nodes {
+		if b, ok := node.(*CfgBlock); ok && b.Global {
+			return b
+		}
+	}
+	return nil
+}
+
+// Media returns the media blocks in the file order.
+func (cfg *UrpmiCfg) Media() []*CfgBlock {
+	res := []*CfgBlock{}
+	for _, node := range cfg.nodes {
+		if b, ok := node.(*CfgBlock); ok && !b.Global {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// FindMedia returns the media with the name or nil.
+func (cfg *UrpmiCfg) FindMedia(name string) *CfgBlock {
+	for _, b := range cfg.Media() {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// AddMedia appends a new empty media block to the end of the file.
+func (cfg *UrpmiCfg) AddMedia(name, url string) *CfgBlock {
+	b := &CfgBlock{Name: name, URL: url, footer: "}", dirty: true}
+
+	if len(cfg.nodes) > 0 {
+		// Keep the trailing newline at the end of the file
+		if last, ok := cfg.nodes[len(cfg.nodes)-1].(*cfgLine); ok && last.raw == "" {
+			cfg.nodes = cfg.nodes[:len(cfg.nodes)-1]
+		}
+		cfg.nodes = append(cfg.nodes, &cfgLine{raw: ""})
+	}
+
+	cfg.nodes = append(cfg.nodes, b, &cfgLine{raw: ""})
+	return b
+}
+
+// RemoveMedia deletes the media block with the name together with the
+// blank line separating it from the neighbours.
+func (cfg *UrpmiCfg) RemoveMedia(name string) bool {
+	blank := func(i int) bool {
+		l, ok := cfg.nodes[i].(*cfgLine)
+		return ok && strings.TrimSpace(l.raw) == ""
+	}
+
+	for i, node := range cfg.nodes {
+		if b, ok := node.(*CfgBlock); ok && !b.Global && b.Name == name {
+			start, end := i, i+1
+			switch {
+			// The last empty line is the newline at the end of the file
+			case end < len(cfg.nodes)-1 && blank(end):
+				end++
+			case start > 0 && blank(start-1):
+				start--
+			}
+
+			cfg.nodes = append(cfg.nodes[:start], cfg.nodes[end:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetName renames the media.
+func (b *CfgBlock) SetName(name string) {
+	b.Name = name
+	b.dirty = true
+}
+
+// SetURL changes the media URL.
+func (b *CfgBlock) SetURL(url string) {
+	b.URL = url
+	b.dirty = true
+}
+
+// Option returns the value of the "key: value" option. Flags like
+// "ignore" have an empty value.
+func (b *CfgBlock) Option(key string) (string, bool) {
+	for _, l := range b.lines {
+		if l.key == key {
+			return l.value, true
+		}
+	}
+	return "", false
+}
+
+// Options returns all options of the block in the file order.
+func (b *CfgBlock) Options() [][2]string {
+	res := [][2]string{}
+	for _, l := range b.lines {
+		if l.key != "" {
+			res = append(res, [2]string{l.key, l.value})
+		}
+	}
+	return res
+}
+
+// SetOption sets the "key: value" option, adding it if necessary.
+func (b *CfgBlock) SetOption(key, value string) {
+	b.setOption(&cfgLine{key: key, value: value, hasValue: true})
+}
+
+// SetFlag adds or removes a flag option like "ignore" or "update".
+func (b *CfgBlock) SetFlag(key string, on bool) {
+	if on {
+		b.setOption(&cfgLine{key: key})
+	} else {
+		b.DeleteOption(key)
+	}
+}
+
+// DeleteOption removes the option.
+func (b *CfgBlock) DeleteOption(key string) {
+	lines := b.lines[:0]
+	for _, l := range b.lines {
+		if l.key != key {
+			lines = append(lines, l)
+		}
+	}
+	b.lines = lines
+}
+
+func (b *CfgBlock) setOption(opt *cfgLine) {
+	for _, l := range b.lines {
+		if l.key == opt.key {
+			if l.value != opt.value || l.hasValue != opt.hasValue {
+				l.value, l.hasValue = opt.value, opt.hasValue
+				l.raw = cfgIndent(l.raw) + l.format()
+			}
+			return
+		}
+	}
+
+	opt.raw = b.indent() + opt.format()
+
+	// Put the option after the last non blank line
+	n := len(b.lines)
+	for n > 0 && b.lines[n-1].key == "" && strings.TrimSpace(b.lines[n-1].raw) == "" {
+		n--
+	}
+	b.lines = append(b.lines[:n], append([]*cfgLine{opt}, b.lines[n:]...)...)
+}
+
+func (b *CfgBlock) indent() string {
+	for _, l := range b.lines {
+		if l.key != "" {
+			return cfgIndent(l.raw)
+		}
+	}
+	return "  "
+}
+
+func (b *CfgBlock) text() []string {
+	res := []string{b.header}
+	if b.dirty {
+		res[0] = b.headerText()
+	}
+
+	for _, l := range b.lines {
+		res = append(res, l.raw)
+	}
+
+	return append(res, b.footer)
+}
+
+func (b *CfgBlock) headerText() string {
+	switch {
+	case b.Global:
+		return "{"
+	case b.URL == "":
+		return quoteCfgWord(b.Name) + " {"
+	}
+	return quoteCfgWord(b.Name) + " " + quoteCfgWord(b.URL) + " {"
+}
+
+func (l *cfgLine) format() string {
+	if l.hasValue {
+		return l.key + ": " + l.value
+	}
+	return l.key
+}
+
+func cfgIndent(s string) string {
+	return s[:len(s)-len(strings.TrimLeftFunc(s, unicode.IsSpace))]
+}
+
+// splitCfgWords splits the line on whitespace, a backslash escapes the
+// next whitespace character.
+func splitCfgWords(s string) []string {
+	res := []string{}
+	word := []rune{}
+	inWord := false
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			if !unicode.IsSpace(c) {
+				word = append(word, '\\')
+			}
+			word = append(word, c)
+			escaped = false
+			inWord = true
+
+		case c == '\\':
+			escaped = true
+
+		case unicode.IsSpace(c):
+			if inWord {
+				res = append(res, string(word))
+				word = word[:0]
+				inWord = false
+			}
+
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		word = append(word, '\\')
+		inWord = true
+	}
+
+	if inWord {
+		res = append(res, string(word))
+	}
+	return res
+}
+
+// quoteCfgWord escapes whitespace with backslashes.
+func quoteCfgWord(s string) string {
+	buf := &bytes.Buffer{}
+	for _, c := range s {
+		if unicode.IsSpace(c) {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2015 Alexander Sokolov <sokoloff.a@gmail.com>.
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testUrpmiCfg = `# Generated by urpmi
+{
+  downloader: wget
+  verify-rpm
+}
+
+Main\ Release http://mirror.rosalab.ru/rosa/rosa2014.1/repository/x86_64/main/release {
+  key-ids: 1212ab12
+  update
+  media_info_dir: media_info
+}
+
+# Disabled by the admin
+Contrib\ Updates {
+  mirrorlist: $MIRRORLIST
+  with-dir: media/contrib/updates
+  xml-info: on-demand
+  priority: 3
+  ignore
+}
+`
+
+func TestParseUrpmiCfg(t *testing.T) {
+	cfg, err := ParseUrpmiCfg(strings.NewReader(testUrpmiCfg))
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "urpmi.cfg")
+	}
+
+	buf := &bytes.Buffer{}
+	cfg.Write(buf)
+	if buf.String() != testUrpmiCfg {
+		t.Errorf(TMPL_MISMATCH, "write", testUrpmiCfg, buf.String())
+	}
+
+	if g := cfg.Global(); g == nil || !reflect.DeepEqual(g.Options(), [][2]string{{"downloader", "wget"}, {"verify-rpm", ""}}) {
+		t.Errorf(TMPL_MISMATCH, "global", "downloader, verify-rpm", g)
+	}
+
+	media := cfg.Media()
+	if len(media) != 2 {
+		t.Fatalf(TMPL_MISMATCH, "media", 2, len(media))
+	}
+
+	rep := NewRepository(media[0])
+	if rep.Name != "Main Release" || rep.URL != "http://mirror.rosalab.ru/rosa/rosa2014.1/repository/x86_64/main/release" ||
+		!rep.Update || rep.Ignore || !reflect.DeepEqual(rep.KeyIds, []string{"1212ab12"}) || rep.MediaInfoDir != "media_info" {
+		t.Errorf(TMPL_MISMATCH, "Main Release", "", rep)
+	}
+
+	rep = NewRepository(media[1])
+	if rep.Name != "Contrib Updates" || rep.URL != "" || rep.Mirrorlist != "$MIRRORLIST" || rep.WithDir != "media/contrib/updates" ||
+		rep.XmlInfo != "on-demand" || rep.Priority != 3 || !rep.Ignore || rep.Update {
+		t.Errorf(TMPL_MISMATCH, "Contrib Updates", "", rep)
+	}
+
+	for _, s := range []string{"}\n", "Main {\n", "{\n}\nignore\n", "a b c {\n}\n"} {
+		if _, err := ParseUrpmiCfg(strings.NewReader(s)); err == nil {
+			t.Errorf("Incorrect config must be rejected: %q", s)
+		}
+	}
+}
+
+func TestWriteUrpmiCfg(t *testing.T) {
+	cfg, err := ParseUrpmiCfg(strings.NewReader(testUrpmiCfg))
+	if err != nil {
+		t.Fatalf(TMPL_ERROR, err, "urpmi.cfg")
+	}
+
+	main := cfg.FindMedia("Main Release")
+	main.SetFlag("ignore", true)
+	main.SetFlag("update", false)
+	main.SetOption("key-ids", "3434cd34")
+
+	contrib := cfg.FindMedia("Contrib Updates")
+	contrib.SetName("Contrib Backports")
+	contrib.SetOption("priority", "3")
+
+	cfg.AddMedia("Local", "file:///mnt/media").SetFlag("update", true)
+
+	expect := strings.Replace(testUrpmiCfg, `  key-ids: 1212ab12
+  update
+  media_info_dir: media_info
+`, `  key-ids: 3434cd34
+  media_info_dir: media_info
+  ignore
+`, 1)
+	expect = strings.Replace(expect, `Contrib\ Updates {`, `Contrib\ Backports {`, 1)
+	expect += "\nLocal file:///mnt/media {\n  update\n}\n"
+
+	buf := &bytes.Buffer{}
+	cfg.Write(buf)
+	if buf.String() != expect {
+		t.Errorf(TMPL_MISMATCH, "write", expect, buf.String())
+	}
+
+	if !cfg.RemoveMedia("Local") || cfg.RemoveMedia("Local") {
+		t.Errorf("Local media must be removed once")
+	}
+
+	expect = strings.TrimSuffix(expect, "\nLocal file:///mnt/media {\n  update\n}\n")
+	buf.Reset()
+	cfg.Write(buf)
+	if buf.String() != expect {
+		t.Errorf(TMPL_MISMATCH, "remove", expect, buf.String())
+	}
+}